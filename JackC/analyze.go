@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"jack"
+	"os"
+	"strings"
+
+	"jack/JackC/ast"
+	"jack/JackC/parser"
+)
+
+// treeOutput is one of the files the analyzer writes for a class Foo:
+// Foo.xml for its parse tree, FooT.xml for its tokens or Foo.json for its
+// tree as JSON.
+type treeOutput struct {
+	filetype string
+	output   func(tree *ast.Class, wr io.Writer)
+}
+
+var parseTreeOutput = treeOutput{".xml", parser.OutputXML}
+var tokensOutput = treeOutput{"T.xml", parser.OutputTokens}
+var jsonOutput = treeOutput{".json", parser.OutputJSON}
+
+// analyze writes the outputs of the classes parsed from sources, or,
+// if compareDir is set, compares them with the files of the same names in
+// compareDir.
+func analyze(sources []jack.Source, classes []*ast.Class, outputs []treeOutput, outDir string, compareDir string) {
+	compared, differing := 0, 0
+	for i, src := range sources {
+		for _, output := range outputs {
+			var buf bytes.Buffer
+			output.output(classes[i], &buf)
+			if compareDir == "" {
+				writeFile(jack.OutputFilename(src, outDir, output.filetype), buf.Bytes())
+				continue
+			}
+			compared++
+			err := compareIgnoringSpace(buf.Bytes(), jack.OutputFilename(src, compareDir, output.filetype))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				differing++
+			}
+		}
+	}
+	if differing > 0 {
+		printErrorAndExit(fmt.Sprintf("%d of %d file(s) differ", differing, compared))
+	}
+	if compareDir != "" {
+		fmt.Printf("%d file(s) match\n", compared)
+	}
+}
+
+// compareIgnoringSpace compares output with the reference file refFilename
+// line by line, like the course's TextComparer: blanks do not count and
+// blank lines are skipped. It fails with the first line that differs.
+func compareIgnoringSpace(output []byte, refFilename string) error {
+	ref, err := os.ReadFile(refFilename)
+	if err != nil {
+		return err
+	}
+	outLines, refLines := significantLines(output), significantLines(ref)
+	for i, refLine := range refLines {
+		if i == len(outLines) {
+			return fmt.Errorf("%s:%d: expected %s, found end of output", refFilename, refLine.lineno, refLine.text)
+		}
+		if outLines[i].squeezed != refLine.squeezed {
+			return fmt.Errorf("%s:%d: expected %s, found %s", refFilename, refLine.lineno, refLine.text, outLines[i].text)
+		}
+	}
+	if len(outLines) > len(refLines) {
+		return fmt.Errorf("%s: expected end of file, found %s", refFilename, outLines[len(refLines)].text)
+	}
+	return nil
+}
+
+type significantLine struct {
+	lineno   int
+	text     string // without leading and trailing blanks
+	squeezed string // without any blanks
+}
+
+// significantLines returns the lines of text that are not blank.
+func significantLines(text []byte) []significantLine {
+	var lines []significantLine
+	for i, line := range strings.Split(string(text), "\n") {
+		squeezed := strings.Join(strings.Fields(line), "")
+		if squeezed != "" {
+			lines = append(lines, significantLine{i + 1, strings.TrimSpace(line), squeezed})
+		}
+	}
+	return lines
+}
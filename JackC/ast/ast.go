@@ -0,0 +1,278 @@
+// Package ast declares the syntax tree of a Jack class, as the JackC parser
+// builds it, and Walk to go through it.
+//
+// Every node knows where it starts in its source file. The tokens a node is
+// made of keep their own positions, those of a subroutine call or of an
+// operator for example, so that tools can point at them.
+package ast
+
+import (
+	"jack"
+	"jack/JackC/symbols"
+)
+
+// Pos is where a token is in its source file.
+type Pos struct {
+	Filename  string
+	Line      int
+	Column    int // of the first character, counted from 1
+	EndColumn int // after the last character
+}
+
+// Span returns the span of p for diagnostics.
+func (p Pos) Span() jack.Span {
+	return jack.Span{Filename: p.Filename, Line: p.Line, Column: p.Column, EndColumn: p.EndColumn}
+}
+
+// Token is a token of Jack source. Type is keyword, symbol, identifier,
+// integerConstant or stringConstant, Value is the text of the token, that of
+// a string constant without its quotes.
+type Token struct {
+	Type  string
+	Value string
+	Pos
+}
+
+// Comment is a comment of the source with its delimiters: // and the rest
+// of its line, or /* or /** up to */.
+type Comment struct {
+	Text string
+	Pos
+}
+
+// Comments are the comments attached to a declaration or a statement. The
+// parser attaches every comment to the node it comes before or ends the
+// line of. A comment inside a node goes to the end of its line, or, in a
+// node with a block, after the '{' that opens it.
+type Comments struct {
+	Doc  []*Comment // on the lines before the node, such as /** */ doc comments
+	Line []*Comment // after the node on its last line, or inside it if it has no block
+	// BlankLine reports whether an empty line separates the node, or its
+	// Doc comments, from what comes before.
+	BlankLine bool
+}
+
+// Node is a node of the tree.
+type Node interface {
+	// Pos returns where the node starts.
+	Pos() Pos
+}
+
+// Statement is one of LetStatement, IfStatement, WhileStatement,
+// DoStatement and ReturnStatement.
+type Statement interface {
+	Node
+	statementNode()
+}
+
+// Expression is one of Constant, Variable, UnaryExpression,
+// BinaryExpression, ParenExpression, IndexExpression and Call.
+type Expression interface {
+	Node
+	expressionNode()
+}
+
+// Class is a class, the root of the tree of a source file.
+type Class struct {
+	Comments
+	Open        []*Comment // before and just after the '{'
+	Keyword     Token      // class
+	Name        Token
+	Vars        []*ClassVarDec
+	Subroutines []*SubroutineDec
+	Close       []*Comment // before the '}' on lines of their own
+	End         []*Comment // on the lines after the class
+	// Precedence reports whether the expressions of the class were parsed
+	// with operator precedence, see BinaryExpression.
+	Precedence bool
+	// Symbols holds the static and field variables of the class.
+	Symbols symbols.SymbolTable
+	// Tokens are all the tokens of the class, if it was parsed from source.
+	Tokens []Token
+}
+
+// ClassVarDec declares static or field variables.
+type ClassVarDec struct {
+	Comments
+	Kind  Token // static or field
+	Type  Token
+	Names []Token
+}
+
+// SubroutineDec declares a constructor, function or method.
+type SubroutineDec struct {
+	Comments
+	Kind       Token // constructor, function or method
+	ReturnType Token
+	Name       Token
+	Parameters []*Parameter
+	Vars       []*VarDec
+	Statements []Statement
+	Open       []*Comment // in the header and just after the '{' of the body
+	Close      []*Comment // before the '}' on lines of their own
+	// Symbols holds the arguments and local variables of the subroutine,
+	// with this as the first argument of a method.
+	Symbols symbols.SymbolTable
+}
+
+type Parameter struct {
+	Type Token
+	Name Token
+}
+
+// VarDec declares local variables.
+type VarDec struct {
+	Comments
+	Keyword Token // var
+	Type    Token
+	Names   []Token
+}
+
+// LetStatement assigns Value to the variable Name or, if Index is not nil,
+// to the element Index of the Array Name.
+type LetStatement struct {
+	Comments
+	Keyword Token
+	Name    Token
+	Index   Expression
+	Value   Expression
+}
+
+// IfStatement has comments of its own where its blocks start and end.
+type IfStatement struct {
+	Comments
+	Keyword   Token
+	Condition Expression
+	Then      []Statement
+	HasElse   bool
+	Else      []Statement
+	Open      []*Comment // in the condition and just after the '{'
+	Close     []*Comment // before the '}' of Then, or up to the else
+	ElseOpen  []*Comment // just after the '{' of Else
+	ElseClose []*Comment // before the '}' of Else
+}
+
+type WhileStatement struct {
+	Comments
+	Keyword   Token
+	Condition Expression
+	Body      []Statement
+	Open      []*Comment // in the condition and just after the '{'
+	Close     []*Comment // before the '}'
+}
+
+type DoStatement struct {
+	Comments
+	Keyword Token
+	Call    *Call
+}
+
+// ReturnStatement returns Value, or nothing if it is nil.
+type ReturnStatement struct {
+	Comments
+	Keyword Token
+	Value   Expression
+}
+
+// Constant is an integer or string constant or one of the keywords true,
+// false, null and this.
+type Constant struct {
+	Token Token
+}
+
+// Variable is the value of a variable.
+type Variable struct {
+	Name Token
+}
+
+// UnaryExpression is -X or ~X.
+type UnaryExpression struct {
+	Op Token
+	X  Expression
+}
+
+// BinaryExpression is X Op Y. Jack applies the operators of an expression
+// from left to right, so a + b * c is (a + b) * c, a BinaryExpression whose
+// X is another one. In a class parsed with operator precedence, operators
+// that bind tighter are applied first and a + b * c is a + (b * c).
+type BinaryExpression struct {
+	X  Expression
+	Op Token
+	Y  Expression
+}
+
+// Precedence returns how tightly the binary operator op binds with
+// operator precedence: * and / bind tighter than + and -, which bind
+// tighter than <, > and =, which bind tighter than & and |. Operators of
+// the same precedence are applied from left to right.
+func Precedence(op string) int {
+	switch op {
+	case "*", "/":
+		return 4
+	case "+", "-":
+		return 3
+	case "<", ">", "=":
+		return 2
+	case "&", "|":
+		return 1
+	}
+	return 0
+}
+
+// ParenExpression is an expression in parentheses.
+type ParenExpression struct {
+	Lparen Token
+	X      Expression
+}
+
+// IndexExpression is the element Index of the Array Name.
+type IndexExpression struct {
+	Name  Token
+	Index Expression
+}
+
+// Call is a call of the subroutine Name of Receiver, a class or an object,
+// or of the class the call is made in if Receiver is nil.
+type Call struct {
+	Receiver  *Token
+	Name      Token
+	Arguments []Expression
+}
+
+func (n *Class) Pos() Pos            { return n.Keyword.Pos }
+func (n *ClassVarDec) Pos() Pos      { return n.Kind.Pos }
+func (n *SubroutineDec) Pos() Pos    { return n.Kind.Pos }
+func (n *Parameter) Pos() Pos        { return n.Type.Pos }
+func (n *VarDec) Pos() Pos           { return n.Keyword.Pos }
+func (n *LetStatement) Pos() Pos     { return n.Keyword.Pos }
+func (n *IfStatement) Pos() Pos      { return n.Keyword.Pos }
+func (n *WhileStatement) Pos() Pos   { return n.Keyword.Pos }
+func (n *DoStatement) Pos() Pos      { return n.Keyword.Pos }
+func (n *ReturnStatement) Pos() Pos  { return n.Keyword.Pos }
+func (n *Constant) Pos() Pos         { return n.Token.Pos }
+func (n *Variable) Pos() Pos         { return n.Name.Pos }
+func (n *UnaryExpression) Pos() Pos  { return n.Op.Pos }
+func (n *BinaryExpression) Pos() Pos { return n.X.Pos() }
+func (n *ParenExpression) Pos() Pos  { return n.Lparen.Pos }
+func (n *IndexExpression) Pos() Pos  { return n.Name.Pos }
+
+func (n *Call) Pos() Pos {
+	if n.Receiver != nil {
+		return n.Receiver.Pos
+	}
+	return n.Name.Pos
+}
+
+func (*LetStatement) statementNode()    {}
+func (*IfStatement) statementNode()     {}
+func (*WhileStatement) statementNode()  {}
+func (*DoStatement) statementNode()     {}
+func (*ReturnStatement) statementNode() {}
+
+func (*Constant) expressionNode()         {}
+func (*Variable) expressionNode()         {}
+func (*UnaryExpression) expressionNode()  {}
+func (*BinaryExpression) expressionNode() {}
+func (*ParenExpression) expressionNode()  {}
+func (*IndexExpression) expressionNode()  {}
+func (*Call) expressionNode()             {}
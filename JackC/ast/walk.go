@@ -0,0 +1,93 @@
+package ast
+
+import "fmt"
+
+// A Visitor's Visit is called by Walk for every node. If it returns a
+// Visitor w, Walk visits the children of the node with w and then calls
+// w.Visit(nil).
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk goes through the tree of node depth first, visiting the children of
+// each node in the order they are written in the source.
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+	switch n := node.(type) {
+	case *Class:
+		for _, dec := range n.Vars {
+			Walk(v, dec)
+		}
+		for _, dec := range n.Subroutines {
+			Walk(v, dec)
+		}
+	case *SubroutineDec:
+		for _, parm := range n.Parameters {
+			Walk(v, parm)
+		}
+		for _, dec := range n.Vars {
+			Walk(v, dec)
+		}
+		walkStatements(v, n.Statements)
+	case *LetStatement:
+		if n.Index != nil {
+			Walk(v, n.Index)
+		}
+		Walk(v, n.Value)
+	case *IfStatement:
+		Walk(v, n.Condition)
+		walkStatements(v, n.Then)
+		walkStatements(v, n.Else)
+	case *WhileStatement:
+		Walk(v, n.Condition)
+		walkStatements(v, n.Body)
+	case *DoStatement:
+		Walk(v, n.Call)
+	case *ReturnStatement:
+		if n.Value != nil {
+			Walk(v, n.Value)
+		}
+	case *UnaryExpression:
+		Walk(v, n.X)
+	case *BinaryExpression:
+		Walk(v, n.X)
+		Walk(v, n.Y)
+	case *ParenExpression:
+		Walk(v, n.X)
+	case *IndexExpression:
+		Walk(v, n.Index)
+	case *Call:
+		for _, arg := range n.Arguments {
+			Walk(v, arg)
+		}
+	case *ClassVarDec, *Parameter, *VarDec, *Constant, *Variable:
+		// no children
+	default:
+		panic(fmt.Sprintf("ast.Walk: unexpected node type %T", n))
+	}
+	v.Visit(nil)
+}
+
+func walkStatements(v Visitor, statements []Statement) {
+	for _, statement := range statements {
+		Walk(v, statement)
+	}
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect walks the tree of node, calling f for every node and, after the
+// children of a node, for nil. The children of a node are skipped if f
+// returns false for it.
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
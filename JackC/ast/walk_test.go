@@ -0,0 +1,112 @@
+package ast
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// recorder writes the type of every node it visits followed by "(", and ")"
+// when it is called with nil after the children.
+type recorder struct {
+	b *strings.Builder
+}
+
+func (r recorder) Visit(node Node) Visitor {
+	if node == nil {
+		r.b.WriteString(")")
+		return nil
+	}
+	r.b.WriteString(nodeName(node) + "(")
+	return r
+}
+
+func nodeName(node Node) string {
+	return strings.TrimPrefix(fmt.Sprintf("%T", node), "*ast.")
+}
+
+// testClass has a node of every type, in the order of
+//
+//	let a[0] = b + (-1);
+//	if (b) { do f(a[1]); } else { return; }
+//	while (true) { return b; }
+func testClass() *Class {
+	return &Class{
+		Vars: []*ClassVarDec{{}},
+		Subroutines: []*SubroutineDec{{
+			Parameters: []*Parameter{{}},
+			Vars:       []*VarDec{{}},
+			Statements: []Statement{
+				&LetStatement{
+					Index: &Constant{},
+					Value: &BinaryExpression{
+						X: &Variable{},
+						Y: &ParenExpression{X: &UnaryExpression{X: &Constant{}}},
+					},
+				},
+				&IfStatement{
+					Condition: &Variable{},
+					Then: []Statement{&DoStatement{Call: &Call{
+						Arguments: []Expression{&IndexExpression{Index: &Constant{}}},
+					}}},
+					HasElse: true,
+					Else:    []Statement{&ReturnStatement{}},
+				},
+				&WhileStatement{
+					Condition: &Constant{},
+					Body:      []Statement{&ReturnStatement{Value: &Variable{}}},
+				},
+			},
+		}},
+	}
+}
+
+func TestWalk(t *testing.T) {
+	var b strings.Builder
+	Walk(recorder{&b}, testClass())
+	want := "Class(ClassVarDec()SubroutineDec(Parameter()VarDec()" +
+		"LetStatement(Constant()BinaryExpression(Variable()ParenExpression(UnaryExpression(Constant()))))" +
+		"IfStatement(Variable()DoStatement(Call(IndexExpression(Constant())))ReturnStatement())" +
+		"WhileStatement(Constant()ReturnStatement(Variable()))))"
+	if got := b.String(); got != want {
+		t.Errorf("Walk visited\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestInspectSkipsChildren(t *testing.T) {
+	var b strings.Builder
+	Inspect(testClass().Subroutines[0].Statements[0], func(node Node) bool {
+		switch node.(type) {
+		case nil:
+			b.WriteString(")")
+		case *BinaryExpression:
+			// no children and no call with nil
+			b.WriteString(nodeName(node))
+			return false
+		default:
+			b.WriteString(nodeName(node) + "(")
+		}
+		return true
+	})
+	want := "LetStatement(Constant()BinaryExpression)"
+	if got := b.String(); got != want {
+		t.Errorf("Inspect visited %s, want %s", got, want)
+	}
+}
+
+// unknownExpression is an Expression that Walk does not know.
+type unknownExpression struct{}
+
+func (unknownExpression) Pos() Pos        { return Pos{} }
+func (unknownExpression) expressionNode() {}
+
+func TestWalkUnknownNode(t *testing.T) {
+	defer func() {
+		r := recover()
+		if r == nil || !strings.Contains(fmt.Sprint(r), "unexpected node type ast.unknownExpression") {
+			t.Errorf("Walk of an unknown node: recovered %v, want a panic naming its type", r)
+		}
+	}()
+	var b strings.Builder
+	Walk(recorder{&b}, &ReturnStatement{Value: unknownExpression{}})
+}
@@ -0,0 +1,266 @@
+// Package format prints Jack classes in the canonical style of jackfmt:
+// four spaces of indentation for every block, the '{' of a block at the
+// end of the line that opens it, one statement or declaration per line and
+// single spaces around binary operators and after commas.
+//
+// Comments stay with the nodes the parser attached them to, so a comment
+// inside a statement moves to the end of its line, or after the '{' of its
+// block. Single empty lines between declarations, statements and doc
+// comments are kept.
+package format
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"jack/JackC/ast"
+	"jack/JackC/parser"
+)
+
+const indentation = "    "
+
+// Source formats the Jack source of a class, which is called name in
+// error messages. It fails with the *jack.Diagnostics of the syntax errors
+// of src if there are any.
+func Source(src []byte, name string) ([]byte, error) {
+	var p parser.Parser
+	class, err := p.Parse(bytes.NewReader(src), name)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	Class(&buf, class)
+	return buf.Bytes(), nil
+}
+
+// Class writes class in the canonical style.
+func Class(w io.Writer, class *ast.Class) error {
+	pr := &printer{precedence: class.Precedence}
+	pr.docComments(class, class.Comments)
+	pr.open("class "+class.Name.Value, class.Open)
+	first := true
+	for _, dec := range class.Vars {
+		pr.separate(dec, dec.Comments, first)
+		pr.line("%s %s %s;", dec.Kind.Value, dec.Type.Value, names(dec.Names))
+		pr.lineComments(dec.Line)
+		first = false
+	}
+	for _, dec := range class.Subroutines {
+		pr.separate(dec, dec.Comments, first)
+		pr.subroutine(dec)
+		first = false
+	}
+	pr.close(class.Close, class.Line)
+	for _, comment := range class.End {
+		pr.comment(comment)
+	}
+	_, err := w.Write(pr.buf.Bytes())
+	return err
+}
+
+// printer writes a class a line at a time. The comments at the end of a
+// line are written by lineComments after the line.
+type printer struct {
+	buf        bytes.Buffer
+	indent     int
+	precedence bool // see ast.Class.Precedence
+}
+
+func (pr *printer) line(format string, a ...interface{}) {
+	pr.buf.WriteString(strings.Repeat(indentation, pr.indent))
+	fmt.Fprintf(&pr.buf, format, a...)
+	pr.buf.WriteByte('\n')
+}
+
+// lineComments writes comments at the end of the line just written.
+func (pr *printer) lineComments(comments []*ast.Comment) {
+	if len(comments) == 0 {
+		return
+	}
+	pr.buf.Truncate(pr.buf.Len() - 1)
+	for _, comment := range comments {
+		pr.buf.WriteByte(' ')
+		pr.buf.WriteString(commentText(comment))
+	}
+	pr.buf.WriteByte('\n')
+}
+
+// separate starts the declaration or statement node: it writes an empty
+// line if the source had one before it, unless it comes first in its
+// block, and then its doc comments.
+func (pr *printer) separate(node ast.Node, comments ast.Comments, first bool) {
+	if comments.BlankLine && !first {
+		pr.buf.WriteByte('\n')
+	}
+	pr.docComments(node, comments)
+}
+
+// docComments writes the doc comments of node, keeping single empty lines
+// between them and after the last.
+func (pr *printer) docComments(node ast.Node, comments ast.Comments) {
+	for i, comment := range comments.Doc {
+		pr.comment(comment)
+		next := node.Pos().Line
+		if i+1 < len(comments.Doc) {
+			next = comments.Doc[i+1].Line
+		}
+		if next > comment.Line+strings.Count(comment.Text, "\n")+1 {
+			pr.buf.WriteByte('\n')
+		}
+	}
+}
+
+// comment writes a comment on lines of its own. The lines of a /* */
+// comment that start with '*' line up with the first; other lines are
+// left as they are.
+func (pr *printer) comment(comment *ast.Comment) {
+	lines := strings.Split(commentText(comment), "\n")
+	aligned := true
+	for _, line := range lines[1:] {
+		aligned = aligned && strings.HasPrefix(strings.TrimSpace(line), "*")
+	}
+	pr.line("%s", lines[0])
+	for _, line := range lines[1:] {
+		if aligned {
+			pr.line(" %s", strings.TrimSpace(line))
+		} else {
+			pr.buf.WriteString(strings.TrimRight(line, " \t") + "\n")
+		}
+	}
+}
+
+func commentText(comment *ast.Comment) string {
+	return strings.ReplaceAll(comment.Text, "\r\n", "\n")
+}
+
+// open writes the line that opens a block, ending in '{', and indents the
+// lines after it.
+func (pr *printer) open(header string, comments []*ast.Comment) {
+	pr.line("%s {", header)
+	pr.lineComments(comments)
+	pr.indent++
+}
+
+// close writes the comments at the end of a block and its '}'.
+func (pr *printer) close(closing []*ast.Comment, line []*ast.Comment) {
+	for _, comment := range closing {
+		pr.comment(comment)
+	}
+	pr.indent--
+	pr.line("}")
+	pr.lineComments(line)
+}
+
+func (pr *printer) subroutine(dec *ast.SubroutineDec) {
+	var parameters []string
+	for _, parm := range dec.Parameters {
+		parameters = append(parameters, parm.Type.Value+" "+parm.Name.Value)
+	}
+	pr.open(dec.Kind.Value+" "+dec.ReturnType.Value+" "+dec.Name.Value+"("+strings.Join(parameters, ", ")+")", dec.Open)
+	first := true
+	for _, varDec := range dec.Vars {
+		pr.separate(varDec, varDec.Comments, first)
+		pr.line("var %s %s;", varDec.Type.Value, names(varDec.Names))
+		pr.lineComments(varDec.Line)
+		first = false
+	}
+	pr.statements(dec.Statements, first)
+	pr.close(dec.Close, dec.Line)
+}
+
+// statements writes statements, the first of their block if first is set.
+func (pr *printer) statements(statements []ast.Statement, first bool) {
+	for _, statement := range statements {
+		switch s := statement.(type) {
+		case *ast.LetStatement:
+			pr.separate(s, s.Comments, first)
+			if s.Index != nil {
+				pr.line("let %s[%s] = %s;", s.Name.Value, pr.expression(s.Index), pr.expression(s.Value))
+			} else {
+				pr.line("let %s = %s;", s.Name.Value, pr.expression(s.Value))
+			}
+			pr.lineComments(s.Line)
+		case *ast.IfStatement:
+			pr.separate(s, s.Comments, first)
+			pr.open("if ("+pr.expression(s.Condition)+")", s.Open)
+			pr.statements(s.Then, true)
+			if s.HasElse {
+				for _, comment := range s.Close {
+					pr.comment(comment)
+				}
+				pr.indent--
+				pr.open("} else", s.ElseOpen)
+				pr.statements(s.Else, true)
+				pr.close(s.ElseClose, s.Line)
+			} else {
+				pr.close(s.Close, s.Line)
+			}
+		case *ast.WhileStatement:
+			pr.separate(s, s.Comments, first)
+			pr.open("while ("+pr.expression(s.Condition)+")", s.Open)
+			pr.statements(s.Body, true)
+			pr.close(s.Close, s.Line)
+		case *ast.DoStatement:
+			pr.separate(s, s.Comments, first)
+			pr.line("do %s;", pr.expression(s.Call))
+			pr.lineComments(s.Line)
+		case *ast.ReturnStatement:
+			pr.separate(s, s.Comments, first)
+			if s.Value != nil {
+				pr.line("return %s;", pr.expression(s.Value))
+			} else {
+				pr.line("return;")
+			}
+			pr.lineComments(s.Line)
+		}
+		first = false
+	}
+}
+
+func names(tokens []ast.Token) string {
+	var result []string
+	for _, token := range tokens {
+		result = append(result, token.Value)
+	}
+	return strings.Join(result, ", ")
+}
+
+// expression returns expr in the canonical style.
+func (pr *printer) expression(expr ast.Expression) string {
+	switch e := expr.(type) {
+	case *ast.Constant:
+		if e.Token.Type == "stringConstant" {
+			return "\"" + e.Token.Value + "\""
+		}
+		return e.Token.Value
+	case *ast.Variable:
+		return e.Name.Value
+	case *ast.UnaryExpression:
+		return e.Op.Value + pr.expression(e.X)
+	case *ast.BinaryExpression:
+		y := pr.expression(e.Y)
+		if _, ok := e.Y.(*ast.BinaryExpression); ok && !pr.precedence {
+			// Y is worked out first, which Jack only does in parentheses
+			// without operator precedence
+			y = "(" + y + ")"
+		}
+		return pr.expression(e.X) + " " + e.Op.Value + " " + y
+	case *ast.ParenExpression:
+		return "(" + pr.expression(e.X) + ")"
+	case *ast.IndexExpression:
+		return e.Name.Value + "[" + pr.expression(e.Index) + "]"
+	case *ast.Call:
+		var arguments []string
+		for _, arg := range e.Arguments {
+			arguments = append(arguments, pr.expression(arg))
+		}
+		call := e.Name.Value + "(" + strings.Join(arguments, ", ") + ")"
+		if e.Receiver != nil {
+			call = e.Receiver.Value + "." + call
+		}
+		return call
+	}
+	return ""
+}
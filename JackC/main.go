@@ -1,21 +1,181 @@
 package main
 
 import (
+	"bytes"
+	"flag"
 	"fmt"
 	"jack"
 	"os"
 
+	"jack/JackC/ast"
 	"jack/JackC/parser"
 )
 
+func printErrorAndExit(err interface{}) {
+	if diagnostics, ok := err.(*jack.Diagnostics); ok {
+		err = diagnostics.Report()
+	}
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
+
 func main() {
-	if len(os.Args) != 2 {
-		fmt.Printf("Usage: <jack file or directory>\n")
+	var output string
+	var outDir string
+	var writeMaps bool
+	flag.StringVar(&output, "o", "", "write all VM code to this file, - for standard output")
+	flag.StringVar(&outDir, "out-dir", "", "write VM files under this directory, mirroring the source tree")
+	var typeCheck bool
+	var strict bool
+	flag.BoolVar(&writeMaps, "map", false, "write a source map <vm file>.map from VM lines to Jack lines")
+	flag.BoolVar(&typeCheck, "typecheck", false, "check types, letting int, char and objects stand for each other")
+	flag.BoolVar(&strict, "strict", false, "check types without letting int, char and objects stand for each other")
+	var precedence bool
+	flag.BoolVar(&precedence, "precedence", false, "apply * and / before + and -, those before <, > and =, and those before & and |, as a //jack:precedence comment before a class does")
+	var xml bool
+	var tokens bool
+	var compareDir string
+	var writeAST bool
+	var fromAST bool
+	flag.BoolVar(&xml, "xml", false, "write the parse tree of each class Foo to Foo.xml instead of compiling")
+	flag.BoolVar(&tokens, "tokens", false, "write the tokens of each class Foo to FooT.xml instead of compiling")
+	flag.BoolVar(&writeAST, "ast", false, "write the tree of each class Foo as JSON to Foo.json instead of compiling")
+	flag.StringVar(&compareDir, "compare", "", "compare the output of -xml, -tokens and -ast, or the XML of both, with the files of this directory instead of writing it")
+	flag.BoolVar(&fromAST, "from-ast", false, "read the classes from .json files written by -ast instead of Jack source")
+	diagnosticFlags := jack.AddDiagnosticFlags(flag.CommandLine)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: JackC [options] <jack file or directory | -> ...\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
 		os.Exit(1)
 	}
-	_, filenames, err := jack.Resolve(os.Args[1], ".jack")
+	if output != "" && outDir != "" {
+		printErrorAndExit("-o and -out-dir cannot be used together")
+	}
+	analyzing := xml || tokens || writeAST || compareDir != ""
+	if output != "" && analyzing {
+		printErrorAndExit("-o cannot be used with -xml, -tokens, -ast or -compare")
+	}
+	err := diagnosticFlags.Setup()
+	if err != nil {
+		printErrorAndExit(err)
+	}
+	compiler := parser.NewCompiler()
+	if strict {
+		compiler.SetTypeCheck(parser.Strict)
+	} else if typeCheck {
+		compiler.SetTypeCheck(parser.Permissive)
+	}
+	compiler.SetPrecedence(precedence)
+	filetype := ".jack"
+	if fromAST {
+		filetype = ".json"
+	}
+	sources, err := jack.ResolveAll(flag.Args(), filetype)
+	if err != nil {
+		printErrorAndExit(err)
+	}
+	var classes []*ast.Class
+	if fromAST {
+		classes, err = readASTs(sources)
+	} else {
+		classes, err = compiler.ParseSources(sources)
+	}
+	if err != nil {
+		printErrorAndExit(err)
+	}
+	if analyzing {
+		// like the analyzer of the course, which does not check the program
+		var outputs []treeOutput
+		if xml {
+			outputs = append(outputs, parseTreeOutput)
+		}
+		if tokens {
+			outputs = append(outputs, tokensOutput)
+		}
+		if writeAST {
+			outputs = append(outputs, jsonOutput)
+		}
+		if len(outputs) == 0 {
+			outputs = []treeOutput{parseTreeOutput, tokensOutput}
+		}
+		analyze(sources, classes, outputs, outDir, compareDir)
+		return
+	}
+	err = compiler.Check(classes)
+	if err != nil {
+		printErrorAndExit(err)
+	}
+	compiler.Diagnostics().Report()
+	codes, maps := compiler.OutputClasses(classes)
+	if output != "" {
+		// the classes are written one after the other
+		var all []byte
+		sourceMap := &jack.SourceMap{Generated: output}
+		for i, code := range codes {
+			sourceMap.Append(maps[i], bytes.Count(all, []byte("\n")))
+			all = append(all, code...)
+		}
+		writeFile(output, all)
+		if writeMaps && output != jack.Stdio {
+			writeMap(sourceMap)
+		}
+		return
+	}
+	for i, src := range sources {
+		vmFilename := jack.OutputFilename(src, outDir, ".vm")
+		sourceMap := maps[i]
+		writeFile(vmFilename, codes[i])
+		if writeMaps && vmFilename != jack.Stdio {
+			sourceMap.Generated = vmFilename
+			writeMap(sourceMap)
+		}
+	}
+}
+
+// readASTs reads the classes of sources from their JSON form. It fails with
+// the *jack.Diagnostics of all the trees if any of them is invalid.
+func readASTs(sources []jack.Source) ([]*ast.Class, error) {
+	var classes []*ast.Class
+	var diagnostics jack.Diagnostics
+	for _, src := range sources {
+		r, err := jack.Open(src.Filename)
+		if err != nil {
+			return nil, err
+		}
+		class, err := parser.ReadJSON(r, src.Filename)
+		r.Close()
+		if classDiagnostics, ok := err.(*jack.Diagnostics); ok {
+			diagnostics.Append(classDiagnostics)
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: %v", src.Filename, err)
+		}
+		classes = append(classes, class)
+	}
+	if diagnostics.Errors() > 0 {
+		return nil, &diagnostics
+	}
+	return classes, nil
+}
+
+func writeFile(filename string, data []byte) {
+	wrt, err := jack.Create(filename)
+	if err != nil {
+		printErrorAndExit(err)
+	}
+	defer wrt.Close()
+	_, err = wrt.Write(data)
+	if err != nil {
+		printErrorAndExit(err)
+	}
+}
+
+func writeMap(sourceMap *jack.SourceMap) {
+	err := sourceMap.WriteFile()
 	if err != nil {
-		panic(err)
+		printErrorAndExit(err)
 	}
-	parser.TokenizeFiles(filenames)
 }
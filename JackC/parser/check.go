@@ -0,0 +1,169 @@
+package parser
+
+import (
+	"jack"
+	"jack/JackC/ast"
+	"jack/JackC/symbols"
+)
+
+func (c *Compiler) errorAt(pos ast.Pos, format string, a ...interface{}) {
+	c.diagnostics.Errorf(pos.Span(), format, a...)
+}
+
+func (c *Compiler) warningAt(pos ast.Pos, format string, a ...interface{}) {
+	c.diagnostics.Warningf(pos.Span(), format, a...)
+}
+
+// Check makes sure that classes, which are all the classes of a program,
+// can be compiled: it builds the table of their subroutines and those of
+// the OS, reports duplicate declarations, unknown types and undeclared
+// variables, and resolves every subroutine call against the table. With
+// SetTypeCheck it checks the types of all expressions as well. If it finds
+// errors it fails with the *jack.Diagnostics of every problem found, which
+// Diagnostics returns as well, and it must have succeeded before the
+// classes are written by OutputClass.
+//
+// A call to a function of a class that is neither in the program nor in
+// the OS only gets a warning, as it may be linked from elsewhere. So does
+// an operator applied after one that binds less tightly in a class parsed
+// without operator precedence, where the result would differ with it.
+func (c *Compiler) Check(classes []*ast.Class) error {
+	c.diagnostics = &jack.Diagnostics{}
+	c.subroutineTable = make(map[string]map[string]*ast.SubroutineDec)
+	// a class of the program replaces the OS class of that name
+	for _, class := range osClasses() {
+		c.subroutineTable[class.Name.Value] = subroutinesOf(class)
+	}
+	declared := make(map[string]Token)
+	for _, class := range classes {
+		name := class.Name
+		if first, ok := declared[name.Value]; ok {
+			c.errorAt(name.Pos, "class %s already declared at %s:%d", name.Value, first.Filename, first.Line)
+			continue
+		}
+		declared[name.Value] = name
+		c.subroutineTable[name.Value] = subroutinesOf(class)
+	}
+	for _, class := range classes {
+		c.checkDeclarations(class)
+		for _, dec := range class.Subroutines {
+			c.checkSubroutineBody(class, dec)
+			if c.typeCheck != NoTypeCheck {
+				c.typeCheckSubroutine(class, dec)
+			}
+		}
+	}
+	if c.diagnostics.Errors() > 0 {
+		return c.diagnostics
+	}
+	return nil
+}
+
+func subroutinesOf(class *ast.Class) map[string]*ast.SubroutineDec {
+	decs := make(map[string]*ast.SubroutineDec)
+	for _, dec := range class.Subroutines {
+		// the first of several declarations counts, see checkDeclarations
+		if _, ok := decs[dec.Name.Value]; !ok {
+			decs[dec.Name.Value] = dec
+		}
+	}
+	return decs
+}
+
+// scope notices names declared twice in one scope.
+type scope map[string]Token
+
+func (c *Compiler) declare(s scope, name Token, what string) {
+	if first, ok := s[name.Value]; ok {
+		c.errorAt(name.Pos, "%s %s already declared at %s:%d", what, name.Value, first.Filename, first.Line)
+		return
+	}
+	s[name.Value] = name
+}
+
+// checkDeclarations checks the declarations of class and its subroutines.
+func (c *Compiler) checkDeclarations(class *ast.Class) {
+	classScope := make(scope)
+	for _, dec := range class.Vars {
+		c.checkType(dec.Type)
+		for _, name := range dec.Names {
+			c.declare(classScope, name, dec.Kind.Value)
+		}
+	}
+	subroutines := make(scope)
+	for _, dec := range class.Subroutines {
+		c.declare(subroutines, dec.Name, "subroutine")
+		if dec.ReturnType.Value != "void" {
+			c.checkType(dec.ReturnType)
+		}
+		subroutineScope := make(scope)
+		for _, parm := range dec.Parameters {
+			c.checkType(parm.Type)
+			c.declare(subroutineScope, parm.Name, "parameter")
+		}
+		for _, varDec := range dec.Vars {
+			c.checkType(varDec.Type)
+			for _, name := range varDec.Names {
+				c.declare(subroutineScope, name, "local variable")
+				symbol := class.Symbols.Lookup(name.Value)
+				if symbol.Exists() && symbol.KindOf() == symbols.FIELD {
+					c.warningAt(name.Pos, "local variable %s shadows a field", name.Value)
+				}
+			}
+		}
+	}
+}
+
+// checkType reports a class type that is neither a class of the program
+// nor of the OS.
+func (c *Compiler) checkType(varType Token) {
+	switch varType.Value {
+	case "int", "char", "boolean":
+		return
+	}
+	if _, ok := c.subroutineTable[varType.Value]; !ok {
+		c.errorAt(varType.Pos, "unknown class %s", varType.Value)
+	}
+}
+
+// checkSubroutineBody checks every variable used by the subroutine dec of
+// class and resolves every call it makes.
+func (c *Compiler) checkSubroutineBody(class *ast.Class, dec *ast.SubroutineDec) {
+	for _, statement := range dec.Statements {
+		ast.Inspect(statement, func(node ast.Node) bool {
+			switch node := node.(type) {
+			case *ast.LetStatement:
+				c.checkVariable(class, dec, node.Name)
+			case *ast.Variable:
+				c.checkVariable(class, dec, node.Name)
+			case *ast.IndexExpression:
+				c.checkVariable(class, dec, node.Name)
+			case *ast.BinaryExpression:
+				if x, ok := node.X.(*ast.BinaryExpression); ok && !class.Precedence &&
+					ast.Precedence(x.Op.Value) < ast.Precedence(node.Op.Value) {
+					c.warningAt(node.Op.Pos, "%s is applied to the result of %s, as Jack has no operator precedence; use parentheses to make the order clear",
+						node.Op.Value, x.Op.Value)
+				}
+			case *ast.Call:
+				resolved, err := c.resolveCall(class, dec, node)
+				if err != nil {
+					c.errorAt(node.Pos(), "%v", err)
+				} else if resolved.external {
+					c.warningAt(node.Pos(), "%s is neither a variable nor a known class, calling function %s", node.Receiver.Value, resolved.function)
+				}
+			}
+			return true
+		})
+	}
+}
+
+func (c *Compiler) checkVariable(class *ast.Class, dec *ast.SubroutineDec, name Token) {
+	symbol := lookupSymbol(class, dec, name.Value)
+	if !symbol.Exists() {
+		c.errorAt(name.Pos, "undeclared variable %s", name.Value)
+		return
+	}
+	if symbol.KindOf() == symbols.FIELD && dec.Kind.Value == "function" {
+		c.errorAt(name.Pos, "field %s used in function %s.%s", name.Value, class.Name.Value, dec.Name.Value)
+	}
+}
@@ -0,0 +1,63 @@
+package parser
+
+import "jack/JackC/ast"
+
+// The parser attaches the comments of the scanner to the nodes it builds,
+// in the order they come in: the comments before a node are its Doc
+// comments, those after its last token on the same line its Line comments.
+
+// takeComments takes the comments not attached yet as long as take is true.
+func (p *Parser) takeComments(take func(c comment) bool) []*ast.Comment {
+	var result []*ast.Comment
+	for p.nextComment < len(p.comments) && take(p.comments[p.nextComment]) {
+		result = append(result, p.comments[p.nextComment].Comment)
+		p.nextComment++
+	}
+	return result
+}
+
+// previousToken returns the token before the current one, if any.
+func (p *Parser) previousToken() (Token, bool) {
+	for i := p.next - 2; i >= 0; i-- {
+		if p.tokens[i].Type != "error" {
+			return p.tokens[i], true
+		}
+	}
+	return Token{}, false
+}
+
+// docComments takes the comments before the current token, the first of a
+// node.
+func (p *Parser) docComments() ast.Comments {
+	current := p.next - 1
+	// the line of whatever comes before the node, a token or a comment
+	previousLine := 0
+	if previous, ok := p.previousToken(); ok {
+		previousLine = previous.Line
+	}
+	if p.nextComment > 0 {
+		previousLine = max(previousLine, p.comments[p.nextComment-1].endLine())
+	}
+	firstLine := p.token.Line
+	if p.nextComment < len(p.comments) && p.comments[p.nextComment].before <= current {
+		firstLine = p.comments[p.nextComment].Line
+	}
+	doc := p.takeComments(func(c comment) bool { return c.before <= current })
+	return ast.Comments{Doc: doc, BlankLine: previousLine > 0 && firstLine > previousLine+1}
+}
+
+// lineComments takes the comments inside what was just parsed, up to the
+// token before the current one, and those after it on the same line.
+func (p *Parser) lineComments() []*ast.Comment {
+	current := p.next - 1
+	last, _ := p.previousToken()
+	return p.takeComments(func(c comment) bool {
+		return c.before < current || c.before == current && c.Line == last.Line
+	})
+}
+
+// commentsBefore takes all the comments before the current token.
+func (p *Parser) commentsBefore() []*ast.Comment {
+	current := p.next - 1
+	return p.takeComments(func(c comment) bool { return c.before <= current })
+}
@@ -2,543 +2,585 @@ package parser
 
 import (
 	"fmt"
+	"jack"
+	"jack/JackC/ast"
 	"jack/JackC/symbols"
-	"os"
-	"path/filepath"
-	"runtime"
 )
 
-var inputC chan Token
-var token Token
-var classSymbolTable symbols.SymbolTable
-var subroutineSymbolTable symbols.SymbolTable
-
-type ClassVarDec struct {
-	staticOrField Token
-	varType       Token
-	varNames      []Token
-}
-
-type Parameter struct {
-	parmType Token
-	name     Token
-}
-
-type VarDec struct {
-	varType Token
-	names   []Token
-}
-
-type SingleTokenTerm struct {
-	value Token
-}
-
-type ArrayAccessTerm struct {
-	varName Token
-	index   Expression
-}
-
-type UnaryOpTerm struct {
-	unaryOp Token
-	term    Term
-}
-
-type OpTerm struct {
-	op  Token
-	rhs Term
-}
-
-type Term struct {
-	term interface{}
-}
-
-type Expression struct {
-	term    Term
-	opTerms []OpTerm
-}
-
-type LetStatement struct {
-	varName         Token
-	isArray         bool
-	indexExpression Expression
-	rhs             Expression
-}
-
-type IfStatement struct {
-	condition  Expression
-	thenClause []Statement
-	isElse     bool
-	elseClause []Statement
-}
-
-type WhileStatement struct {
-	condition Expression
-	stmts     []Statement
-}
-
-type MethodCall struct {
-	classOrVarName Token
-	methodName     Token
-	arguments      []Expression
-}
-
-type FunctionCall struct {
-	functionName Token
-	arguments    []Expression
-}
-
-type DoStatement struct {
-	subroutineCall interface{} // either MethodCall or FunctionCall
+// Parser parses a Jack class. It holds the tokens and the state of the
+// parser for one file, so that the classes of a program can be parsed by
+// Parsers of their own in parallel.
+type Parser struct {
+	// tokens are those of the whole file, the one after token is tokens[next]
+	tokens []Token
+	next   int
+	token  Token
+	// the symbol tables of the class and of the subroutine being parsed
+	classSymbolTable      symbols.SymbolTable
+	subroutineSymbolTable symbols.SymbolTable
+	// precedence is set by SetPrecedence, withPrecedence when the class
+	// being parsed is parsed with operator precedence
+	precedence     bool
+	withPrecedence bool
+	// comments are those of the whole file, the first not attached to the
+	// tree yet is comments[nextComment]
+	comments    []comment
+	nextComment int
+	// skippedToEnd is set when skipAfterError skipped the rest of the file,
+	// closing brace of the class included.
+	skippedToEnd bool
+	diagnostics  jack.Diagnostics
+}
+
+func (p *Parser) errorAt(token Token, format string, a ...interface{}) {
+	p.diagnostics.Errorf(token.Span(), format, a...)
+}
+
+// syntaxError is what expect panics with when the parser meets a token it
+// did not expect. It is recovered where the parser can carry on after
+// skipping to a token it knows again, see recoverAt.
+type syntaxError struct{}
+
+func (p *Parser) getToken() {
+	if p.token.Type == "eof" {
+		return
+	}
+	p.token = p.tokens[p.next]
+	p.next++
+	// errors of the tokenizer come as tokens, so they are reported in order
+	for p.token.Type == "error" {
+		p.errorAt(p.token, "%s", p.token.Value)
+		p.token = p.tokens[p.next]
+		p.next++
+	}
 }
 
-type ReturnStatement struct {
-	isEmpty          bool
-	returnExpression Expression
+// describeToken says what token is in an error message.
+func describeToken(t Token) string {
+	switch t.Type {
+	case "eof":
+		return "end of file"
+	case "stringConstant":
+		return fmt.Sprintf("string constant \"%s\"", t.Value)
+	}
+	return fmt.Sprintf("'%s'", t.Value)
 }
 
-type Statement struct {
-	stmt interface{}
+// expect reports a syntax error and abandons what is being parsed unless
+// condition holds. what is what was expected instead of the current token.
+func (p *Parser) expect(condition bool, what string) {
+	if !condition {
+		p.errorAt(p.token, "expected %s, found %s", what, describeToken(p.token))
+		panic(syntaxError{})
+	}
 }
 
-type SubroutineBody struct {
-	varDecs    []VarDec
-	statements []Statement
+func (p *Parser) expectIdentifier(what string) {
+	p.expect(p.token.Type == "identifier", what)
 }
 
-type SubroutineDec struct {
-	ctrOrFuncOrMethod Token
-	returnType        Token
-	name              Token
-	parameters        []Parameter
-	body              SubroutineBody
-	symbolTable       symbols.SymbolTable
+func (p *Parser) expectSymbol(symbol string) {
+	p.expect(p.token.Value == symbol && p.token.Type == "symbol", "'"+symbol+"'")
 }
 
-type ClassTree struct {
-	className      Token
-	classVarDecs   []ClassVarDec
-	subroutineDecs []SubroutineDec
-	symbolTable    symbols.SymbolTable
+// recoverAt is deferred by the parts of the parser that can carry on after
+// a syntax error. It stops the panic of expect and skips tokens up to the
+// end of the broken part: a ';' at the same nesting of braces, which is
+// skipped as well, or a '}' closing the enclosing block or a token for which
+// stop is true, where the enclosing part takes over again.
+func (p *Parser) recoverAt(stop func() bool) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	if _, ok := r.(syntaxError); !ok {
+		panic(r)
+	}
+	depth := 0
+	for p.token.Type != "eof" {
+		switch {
+		case p.token.Value == "{":
+			depth++
+		case p.token.Value == "}" && depth == 0:
+			return
+		case p.token.Value == "}":
+			depth--
+		case p.token.Value == ";" && depth == 0:
+			p.getToken()
+			return
+		case depth == 0 && stop():
+			return
+		}
+		p.getToken()
+	}
 }
 
-func compileFile(ch chan Token, filename string) {
-	inputC = ch
-	outDir := filepath.Dir(filename)
-	outputFile := filepath.Base(filename)
-	outputFile = outputFile[0:len(outputFile)-5] + ".vm"
-	outputFile = filepath.Join(outDir, outputFile)
-	writer, err := os.Create(outputFile)
-	if err != nil {
-		panic(err)
+// skipAfterError is deferred where the parser can only carry on at a token
+// for which stop is true. It stops the panic of expect and skips to such a
+// token or the end of the file.
+func (p *Parser) skipAfterError(stop func() bool) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	if _, ok := r.(syntaxError); !ok {
+		panic(r)
+	}
+	for p.token.Type != "eof" && !stop() {
+		p.getToken()
 	}
-	classTree := compileClass()
-	outputJackVM(classTree, writer)
+	p.skippedToEnd = p.token.Type == "eof"
+}
+
+func (p *Parser) compileClass() (result *ast.Class) {
+	result = &ast.Class{}
+	defer p.skipAfterError(func() bool { return false })
+	p.skippedToEnd = false
+	p.getToken()
+	result.Comments = p.docComments()
+	p.expect(p.token.Value == "class", "'class'")
+	result.Keyword = p.token
+	p.getToken()
+	p.expectIdentifier("class name")
+	result.Name = p.token
+	result.Symbols = symbols.NewSymbolTable(p.token.Value)
+	p.classSymbolTable = result.Symbols
+	p.getToken()
+	p.expectSymbol("{")
+	p.getToken()
+	result.Open = p.lineComments()
+	result.Vars = p.compileClassVarDecs()
+	result.Subroutines = p.compileSubroutineDecs()
+	if p.skippedToEnd {
+		return result
+	}
+	p.expectSymbol("}")
+	result.Close = p.commentsBefore()
+	p.getToken()
+	result.Line = p.lineComments()
+	p.expect(p.token.Type == "eof", "end of file")
+	result.End = p.commentsBefore()
+	return result
 }
 
-func errorOut(msg string) {
-	fmt.Printf("Error line %d file %s: %s\n", token.lineno, token.file, msg)
-	os.Exit(1)
+func (p *Parser) isSubroutineKeyword() bool {
+	return p.token.Value == "constructor" || p.token.Value == "function" || p.token.Value == "method"
 }
 
-func getToken() {
-	token = <-inputC
-	// pc, fn, line, _ := runtime.Caller(1)
-	// fmt.Printf("  in %s[%s:%d]\n", runtime.FuncForPC(pc).Name(), fn, line)
-	// fmt.Printf("file: %s(%d): token type %s, value %s\n", token.file, token.lineno, token.tokenType, token.value)
+func (p *Parser) compileSubroutineDecs() []*ast.SubroutineDec {
+	var result []*ast.SubroutineDec
+	for p.isSubroutineKeyword() {
+		result = append(result, p.compileSubroutineDec())
+	}
+	return result
 }
 
-func unless(condition bool, msg string) bool {
-	pc, fn, line, _ := runtime.Caller(1)
-	errmsg := fmt.Sprintf("[error] in %s[%s:%d] %s", runtime.FuncForPC(pc).Name(), fn, line, msg)
-	if !condition {
-		errorOut(errmsg)
+func (p *Parser) compileSubroutineDec() (subroutineDec *ast.SubroutineDec) {
+	subroutineDec = &ast.SubroutineDec{Comments: p.docComments()}
+	// the body may hold any number of braces, so go on with the next subroutine
+	defer p.skipAfterError(p.isSubroutineKeyword)
+	if !p.compileSubroutineHeader(subroutineDec) && p.token.Value != "{" {
+		return subroutineDec
 	}
+	p.compileSubroutineBody(subroutineDec)
+	return subroutineDec
+}
+
+// compileSubroutineHeader parses what comes before the body of a subroutine.
+// After a syntax error it skips to the body.
+func (p *Parser) compileSubroutineHeader(subroutineDec *ast.SubroutineDec) (ok bool) {
+	defer p.skipAfterError(func() bool { return p.token.Value == "{" || p.isSubroutineKeyword() })
+	subroutineDec.Kind = p.token
+	p.getToken()
+	p.expect(p.token.Value == "void" || p.isType(), "return type")
+	subroutineDec.ReturnType = p.token
+	p.getToken()
+	p.expectIdentifier("subroutine name")
+	subroutineDec.Name = p.token
+	symbolTable := symbols.NewSymbolTable(p.token.Value)
+	subroutineDec.Symbols = symbolTable
+	p.subroutineSymbolTable = symbolTable
+	if subroutineDec.Kind.Value == "method" {
+		symbolTable.Define("this", p.classSymbolTable.Name, symbols.ARGUMENT)
+	}
+	p.getToken()
+	p.expectSymbol("(")
+	p.getToken()
+	subroutineDec.Parameters = p.compileParmList()
+	p.expectSymbol(")")
+	p.getToken()
 	return true
 }
 
-func unlessIdentifier(msg string) bool {
-	unless(token.tokenType == "identifier", msg)
-	return true
+func (p *Parser) compileSubroutineBody(subroutineDec *ast.SubroutineDec) {
+	p.expectSymbol("{")
+	p.getToken()
+	subroutineDec.Open = p.lineComments()
+	subroutineDec.Vars = p.compileVarDecs()
+	subroutineDec.Statements = p.compileStatements()
+	p.expectSymbol("}")
+	subroutineDec.Close = p.commentsBefore()
+	p.getToken()
+	subroutineDec.Line = p.lineComments()
+}
+
+func (p *Parser) isStatement() bool {
+	return p.token.Value == "let" || p.token.Value == "if" || p.token.Value == "while" || p.token.Value == "do" || p.token.Value == "return"
+}
+
+// compileStatements parses statements up to the '}' that ends them. A
+// statement with a syntax error is skipped, and so is anything else that
+// does not start a statement.
+func (p *Parser) compileStatements() []ast.Statement {
+	var result []ast.Statement
+	for p.token.Value != "}" && p.token.Type != "eof" && !p.isSubroutineKeyword() {
+		if !p.isStatement() {
+			func() {
+				defer p.recoverAt(p.isStatement)
+				p.expect(false, "statement")
+			}()
+			continue
+		}
+		statement, ok := p.compileStatement()
+		if ok {
+			result = append(result, statement)
+		}
+	}
+	return result
 }
 
-func compileClass() ClassTree {
-	result := ClassTree{}
-	getToken()
-	unless(token.value == "class", "File must declare a class")
-	getToken()
-	unlessIdentifier("Expected classname")
-	result.className = token
-	result.symbolTable = symbols.NewSymbolTable(token.value)
-	classSymbolTable = result.symbolTable
-	getToken()
-	unless(token.value == "{", "expected {")
-	getToken()
-	result.classVarDecs = compileClassVarDecs()
-	result.subroutineDecs = compileSubroutineDecs()
-	unless(token.value == "}", "expected }")
-	return result
+func (p *Parser) compileStatement() (result ast.Statement, ok bool) {
+	defer p.recoverAt(p.isStatement)
+	switch p.token.Value {
+	case "let":
+		result = p.compileLetStatement()
+	case "if":
+		result = p.compileIfStatement()
+	case "while":
+		result = p.compileWhileStatement()
+	case "do":
+		result = p.compileDoStatement()
+	case "return":
+		result = p.compileReturnStatement()
+	}
+	return result, true
 }
 
-func compileSubroutineDecs() []SubroutineDec {
-	var result []SubroutineDec
-	for token.value == "constructor" || token.value == "function" || token.value == "method" {
-		subroutineDec := SubroutineDec{ctrOrFuncOrMethod: token}
-		getToken()
-		unless(token.value == "void" || IsType(), "Expected 'void' or identifier")
-		subroutineDec.returnType = token
-		getToken()
-		unlessIdentifier("Expected subroutine name")
-		subroutineDec.name = token
-		symbolTable := symbols.NewSymbolTable(token.value)
-		subroutineDec.symbolTable = symbolTable
-		subroutineSymbolTable = symbolTable
-		if subroutineDec.ctrOrFuncOrMethod.value == "method" {
-			symbolTable.Define("this", classSymbolTable.Name, symbols.ARGUMENT)
-		}
-		getToken()
-		unless(token.value == "(", "Expected (")
-		getToken()
-		subroutineDec.parameters = compileParmList()
-		unless(token.value == ")", "Expected )")
-		getToken()
-		subroutineDec.body = compileSubroutineBody()
-		result = append(result, subroutineDec)
+func (p *Parser) compileReturnStatement() *ast.ReturnStatement {
+	result := &ast.ReturnStatement{Comments: p.docComments(), Keyword: p.token}
+	p.getToken()
+	if p.token.Value != ";" {
+		result.Value = p.compileExpression()
 	}
+	p.expectSymbol(";")
+	p.getToken()
+	result.Line = p.lineComments()
 	return result
 }
 
-func compileSubroutineBody() SubroutineBody {
-	var result SubroutineBody
-	unless(token.value == "{", "Expected beginning of subroutine body '{'")
-	getToken()
-	result = SubroutineBody{}
-	result.varDecs = compileVarDecs()
-	result.statements = compileStatements()
-	unless(token.value == "}", "Expected { at end of subroutine body")
-	getToken()
+func (p *Parser) compileDoStatement() *ast.DoStatement {
+	result := &ast.DoStatement{Comments: p.docComments(), Keyword: p.token}
+	p.getToken()
+	p.expectIdentifier("subroutine, variable or class name")
+	initialToken := p.token
+	p.getToken()
+	p.expect(p.token.Value == "(" || p.token.Value == ".", "'(' or '.'")
+	result.Call = p.compileSubroutineCall(initialToken)
+	p.expectSymbol(";")
+	p.getToken()
+	result.Line = p.lineComments()
 	return result
 }
 
-func isStatement() bool {
-	return token.value == "let" || token.value == "if" || token.value == "while" || token.value == "do" || token.value == "return"
+func (p *Parser) compileWhileStatement() *ast.WhileStatement {
+	result := &ast.WhileStatement{Comments: p.docComments(), Keyword: p.token}
+	p.getToken()
+	result.Condition = p.compileParenthesizedExpression()
+	result.Open, result.Body, result.Close = p.compileBlockOfStatements()
+	result.Line = p.lineComments()
+	return result
 }
 
-func compileStatements() []Statement {
-	var result []Statement
-	for isStatement() {
-		statement := compileStatement()
-		result = append(result, statement)
-	}
+func (p *Parser) compileParenthesizedExpression() ast.Expression {
+	p.expectSymbol("(")
+	p.getToken()
+	result := p.compileExpression()
+	p.expectSymbol(")")
+	p.getToken()
 	return result
 }
 
-func compileStatement() Statement {
-	switch token.value {
-	case "let":
-		return compileLetStatement()
-	case "if":
-		return compileIfStatement()
-	case "while":
-		return compileWhileStatement()
-	case "do":
-		return compileDoStatement()
-	case "return":
-		return compileReturnStatement()
-	}
-	return Statement{}
-}
-
-func compileReturnStatement() Statement {
-	result := ReturnStatement{}
-	getToken()
-	if token.value == ";" {
-		getToken()
-		result.isEmpty = true
-		return Statement{stmt: result}
-	}
-	result.returnExpression = compileExpression()
-	result.isEmpty = false
-	unless(token.value == ";", "Expecting ;")
-	getToken()
-	return Statement{stmt: result}
-}
-
-func compileDoStatement() Statement {
-	result := DoStatement{}
-	getToken()
-	unlessIdentifier("expecting name of subroutine or variable or class")
-	initialToken := token
-	getToken()
-	result.subroutineCall = compileSubroutineCall(initialToken)
-	unless(token.value == ";", "Expecting ;")
-	getToken()
-	return Statement{stmt: result}
-}
-
-func compileWhileStatement() Statement {
-	result := WhileStatement{}
-	getToken()
-	result.condition = compileParenthesizedExpression()
-	result.stmts = compileBlockOfStatements()
-	return Statement{stmt: result}
-}
-
-func compileParenthesizedExpression() Expression {
-	unless(token.value == "(", "Expecting (")
-	getToken()
-	result := compileExpression()
-	unless(token.value == ")", "Expecting )")
-	getToken()
+// compileBlockOfStatements parses statements in braces, with the comments
+// just after the '{' and those before the '}'.
+func (p *Parser) compileBlockOfStatements() (opening []*ast.Comment, statements []ast.Statement, closing []*ast.Comment) {
+	p.expectSymbol("{")
+	p.getToken()
+	opening = p.lineComments()
+	statements = p.compileStatements()
+	p.expectSymbol("}")
+	closing = p.commentsBefore()
+	p.getToken()
+	return opening, statements, closing
+}
+
+func (p *Parser) compileIfStatement() *ast.IfStatement {
+	result := &ast.IfStatement{Comments: p.docComments(), Keyword: p.token}
+	p.getToken()
+	result.Condition = p.compileParenthesizedExpression()
+	result.Open, result.Then, result.Close = p.compileBlockOfStatements()
+	if p.token.Value == "else" {
+		result.HasElse = true
+		result.Close = append(result.Close, p.commentsBefore()...)
+		p.getToken()
+		result.ElseOpen, result.Else, result.ElseClose = p.compileBlockOfStatements()
+	}
+	result.Line = p.lineComments()
 	return result
 }
 
-func compileBlockOfStatements() []Statement {
-	unless(token.value == "{", "Expecting {")
-	getToken()
-	result := compileStatements()
-	unless(token.value == "}", "Expecting }")
-	getToken()
+func (p *Parser) compileLetStatement() *ast.LetStatement {
+	result := &ast.LetStatement{Comments: p.docComments(), Keyword: p.token}
+	p.getToken()
+	p.expectIdentifier("variable name")
+	result.Name = p.token
+	p.getToken()
+	if p.token.Value == "[" {
+		p.getToken()
+		result.Index = p.compileExpression()
+		p.expectSymbol("]")
+		p.getToken()
+	}
+	p.expectSymbol("=")
+	p.getToken()
+	result.Value = p.compileExpression()
+	p.expectSymbol(";")
+	p.getToken()
+	result.Line = p.lineComments()
 	return result
 }
 
-func compileIfStatement() Statement {
-	result := IfStatement{isElse: false}
-	getToken()
-	result.condition = compileParenthesizedExpression()
-	result.thenClause = compileBlockOfStatements()
-	if token.value == "else" {
-		result.isElse = true
-		getToken()
-		result.elseClause = compileBlockOfStatements()
-	}
-	return Statement{stmt: result}
-}
-
-func compileLetStatement() Statement {
-	result := LetStatement{isArray: false}
-	getToken()
-	unlessIdentifier("Expecting variable name")
-	result.varName = token
-	getToken()
-	if token.value == "[" {
-		result.isArray = true
-		getToken()
-		result.indexExpression = compileExpression()
-		unless(token.value == "]", "Expected ]")
-		getToken()
-	}
-	unless(token.value == "=", "Expected =")
-	getToken()
-	result.rhs = compileExpression()
-	unless(token.value == ";", "Expecting ;")
-	getToken()
-	return Statement{stmt: result}
-}
-
-func compileVarDecs() []VarDec {
-	var result []VarDec
-	for token.value == "var" {
-		varDec := compileVarDec()
-		result = append(result, varDec)
+func (p *Parser) compileVarDecs() []*ast.VarDec {
+	var result []*ast.VarDec
+	for p.token.Value == "var" {
+		varDec, ok := p.compileVarDec()
+		if ok {
+			result = append(result, varDec)
+		}
 	}
 	return result
 }
 
-func compileVarDec() VarDec {
-	result := VarDec{}
-	getToken()
-	unless(IsType(), "Expected type")
-	result.varType = token
-	getToken()
-	for unlessIdentifier("Expected variable name") {
-		subroutineSymbolTable.Define(token.value, result.varType.value, symbols.VAR)
-		result.names = append(result.names, token)
-		getToken()
-		if token.value == "," {
-			getToken()
-			continue
-		} else {
+func (p *Parser) compileVarDec() (result *ast.VarDec, ok bool) {
+	defer p.recoverAt(func() bool { return p.token.Value == "var" || p.isStatement() })
+	result = &ast.VarDec{Comments: p.docComments(), Keyword: p.token}
+	p.getToken()
+	p.expect(p.isType(), "type")
+	result.Type = p.token
+	p.getToken()
+	for {
+		p.expectIdentifier("variable name")
+		p.subroutineSymbolTable.Define(p.token.Value, result.Type.Value, symbols.VAR)
+		result.Names = append(result.Names, p.token)
+		p.getToken()
+		if p.token.Value != "," {
 			break
 		}
+		p.getToken()
 	}
-	unless(token.value == ";", "Expected ;")
-	getToken()
-	return result
+	p.expectSymbol(";")
+	p.getToken()
+	result.Line = p.lineComments()
+	return result, true
 }
 
-func IsType() bool {
-	return token.value == "int" || token.value == "char" || token.value == "boolean" || token.tokenType == "identifier"
+func (p *Parser) isType() bool {
+	return p.token.Value == "int" || p.token.Value == "char" || p.token.Value == "boolean" || p.token.Type == "identifier"
 }
 
-func compileParmList() []Parameter {
-	var result []Parameter
-	for IsType() {
-		parm := Parameter{parmType: token}
-		getToken()
-		unlessIdentifier("expected parameter name")
-		parm.name = token
-		getToken()
-		subroutineSymbolTable.Define(parm.name.value, parm.parmType.value, symbols.ARGUMENT)
+func (p *Parser) compileParmList() []*ast.Parameter {
+	var result []*ast.Parameter
+	for p.isType() {
+		parm := &ast.Parameter{Type: p.token}
+		p.getToken()
+		p.expectIdentifier("parameter name")
+		parm.Name = p.token
+		p.getToken()
+		p.subroutineSymbolTable.Define(parm.Name.Value, parm.Type.Value, symbols.ARGUMENT)
 		result = append(result, parm)
-		if token.value == "," {
-			getToken()
-			continue
-		} else {
+		if p.token.Value != "," {
 			break
 		}
+		p.getToken()
+		p.expect(p.isType(), "parameter type")
 	}
 	return result
 }
 
-func compileClassVarDec() ClassVarDec {
-	result := ClassVarDec{staticOrField: token}
+func (p *Parser) isClassVarKeyword() bool {
+	return p.token.Value == "static" || p.token.Value == "field"
+}
+
+func (p *Parser) compileClassVarDec() (result *ast.ClassVarDec, ok bool) {
+	defer p.recoverAt(func() bool { return p.isClassVarKeyword() || p.isSubroutineKeyword() })
+	result = &ast.ClassVarDec{Comments: p.docComments(), Kind: p.token}
 	var symbolKind symbols.SymbolKind
-	if token.value == "static" {
+	if p.token.Value == "static" {
 		symbolKind = symbols.STATIC
 	} else {
 		symbolKind = symbols.FIELD
 	}
-	getToken()
-	unless(token.value == "int" || token.value == "char" || token.value == "boolean" || token.tokenType == "identifier",
-		"expected type or identifier")
-	result.varType = token
-	getToken()
-	for unlessIdentifier("expected variable name") {
-		classSymbolTable.Define(token.value, result.varType.value, symbolKind)
-		result.varNames = append(result.varNames, token)
-		getToken()
-		if token.value == "," {
-			getToken()
-		} else {
+	p.getToken()
+	p.expect(p.isType(), "type")
+	result.Type = p.token
+	p.getToken()
+	for {
+		p.expectIdentifier("variable name")
+		p.classSymbolTable.Define(p.token.Value, result.Type.Value, symbolKind)
+		result.Names = append(result.Names, p.token)
+		p.getToken()
+		if p.token.Value != "," {
 			break
 		}
+		p.getToken()
 	}
-	unless(token.value == ";", "expecting ;")
-	getToken()
-	return result
-}
-
-func compileClassVarDecs() []ClassVarDec {
-	var result []ClassVarDec
-	for token.value == "static" || token.value == "field" {
-		classVar := compileClassVarDec()
-		result = append(result, classVar)
+	p.expectSymbol(";")
+	p.getToken()
+	result.Line = p.lineComments()
+	return result, true
+}
+
+func (p *Parser) compileClassVarDecs() []*ast.ClassVarDec {
+	var result []*ast.ClassVarDec
+	for p.isClassVarKeyword() {
+		classVar, ok := p.compileClassVarDec()
+		if ok {
+			result = append(result, classVar)
+		}
 	}
 	return result
 }
 
-func IsTerm() bool {
-	returnValue := token.tokenType == "integerConstant" || token.tokenType == "stringConstant" ||
-		token.value == "true" || token.value == "false" || token.value == "null" || token.value == "this" ||
-		token.value == "(" || token.value == "-" || token.value == "~" || token.tokenType == "identifier"
+func (p *Parser) isTerm() bool {
+	returnValue := p.token.Type == "integerConstant" || p.token.Type == "stringConstant" ||
+		p.token.Value == "true" || p.token.Value == "false" || p.token.Value == "null" || p.token.Value == "this" ||
+		p.token.Value == "(" || p.token.Value == "-" || p.token.Value == "~" || p.token.Type == "identifier"
 	return returnValue
 }
 
-func compileTerm() Term {
-	var term Term
-	if token.value == "-" || token.value == "~" {
-		unaryOpTerm := UnaryOpTerm{unaryOp: token}
-		getToken()
-		unaryOpTerm.term = compileTerm()
-		term.term = unaryOpTerm
-		return term
-	}
-	if token.tokenType == "integerConstant" || token.tokenType == "stringConstant" ||
-		token.value == "true" || token.value == "false" || token.value == "null" || token.value == "this" {
-		singleTokenTerm := SingleTokenTerm{value: token}
-		term.term = singleTokenTerm
-		getToken()
-		return term
-	}
-	if token.value == "(" {
-		getToken()
-		innerExpression := compileExpression()
-		term = Term{term: innerExpression}
-		unless(token.value == ")", "Expecting )")
-		getToken()
-		return term
-	}
-	if unlessIdentifier("expecting term") {
-		term = Term{term: compileTermWithIdentifier()}
-		return term
-	}
-	return term
-}
-
-func compileTermWithIdentifier() interface{} {
-	initialToken := token
-	getToken()
-	if token.value == "[" {
-		return compileArrayAccessTerm(initialToken)
-	} else if token.value == "(" || token.value == "." {
-		return compileSubroutineCall(initialToken)
+func (p *Parser) compileTerm() ast.Expression {
+	if p.token.Value == "-" || p.token.Value == "~" {
+		unary := &ast.UnaryExpression{Op: p.token}
+		p.getToken()
+		unary.X = p.compileTerm()
+		return unary
+	}
+	if p.token.Type == "integerConstant" || p.token.Type == "stringConstant" ||
+		p.token.Value == "true" || p.token.Value == "false" || p.token.Value == "null" || p.token.Value == "this" {
+		constant := &ast.Constant{Token: p.token}
+		p.getToken()
+		return constant
+	}
+	if p.token.Value == "(" {
+		paren := &ast.ParenExpression{Lparen: p.token}
+		p.getToken()
+		paren.X = p.compileExpression()
+		p.expectSymbol(")")
+		p.getToken()
+		return paren
+	}
+	p.expectIdentifier("expression")
+	return p.compileTermWithIdentifier()
+}
+
+func (p *Parser) compileTermWithIdentifier() ast.Expression {
+	initialToken := p.token
+	p.getToken()
+	if p.token.Value == "[" {
+		return p.compileArrayAccessTerm(initialToken)
+	} else if p.token.Value == "(" || p.token.Value == "." {
+		return p.compileSubroutineCall(initialToken)
 	} else {
-		return SingleTokenTerm{value: initialToken}
+		return &ast.Variable{Name: initialToken}
 	}
 }
 
-func compileArrayAccessTerm(initialToken Token) ArrayAccessTerm {
-	result := ArrayAccessTerm{varName: initialToken}
-	getToken()
-	result.index = compileExpression()
-	unless(token.value == "]", "Expecting ]")
-	getToken()
+func (p *Parser) compileArrayAccessTerm(initialToken Token) *ast.IndexExpression {
+	result := &ast.IndexExpression{Name: initialToken}
+	p.getToken()
+	result.Index = p.compileExpression()
+	p.expectSymbol("]")
+	p.getToken()
 	return result
 }
 
-func compileSubroutineCall(initialToken Token) interface{} {
-	if token.value == "(" {
-		functionCall := FunctionCall{functionName: initialToken}
-		getToken()
-		functionCall.arguments = compileArgumentList()
-		unless(token.value == ")", "Expecting )")
-		getToken()
+func (p *Parser) compileSubroutineCall(initialToken Token) *ast.Call {
+	if p.token.Value == "(" {
+		functionCall := &ast.Call{Name: initialToken}
+		p.getToken()
+		functionCall.Arguments = p.compileArgumentList()
+		p.expectSymbol(")")
+		p.getToken()
 		return functionCall
 	}
-	if token.value == "." {
-		methodCall := MethodCall{classOrVarName: initialToken}
-		getToken()
-		unlessIdentifier("expecting identifier after .")
-		methodCall.methodName = token
-		getToken()
-		unless(token.value == "(", "expecting (")
-		getToken()
-		methodCall.arguments = compileArgumentList()
-		unless(token.value == ")", "Expecting )")
-		getToken()
-		return methodCall
-	}
-	return nil
-}
-
-func IsOp() bool {
-	returnValue := token.value == "+" || token.value == "-" || token.value == "*" || token.value == "/" ||
-		token.value == "&" || token.value == "|" || token.value == "<" || token.value == ">" || token.value == "="
+	methodCall := &ast.Call{Receiver: &initialToken}
+	p.getToken()
+	p.expectIdentifier("subroutine name")
+	methodCall.Name = p.token
+	p.getToken()
+	p.expectSymbol("(")
+	p.getToken()
+	methodCall.Arguments = p.compileArgumentList()
+	p.expectSymbol(")")
+	p.getToken()
+	return methodCall
+}
+
+func (p *Parser) isOp() bool {
+	returnValue := p.token.Value == "+" || p.token.Value == "-" || p.token.Value == "*" || p.token.Value == "/" ||
+		p.token.Value == "&" || p.token.Value == "|" || p.token.Value == "<" || p.token.Value == ">" || p.token.Value == "="
 	return returnValue
 }
 
-func compileExpression() Expression {
-	result := Expression{term: compileTerm()}
-	for IsOp() {
-		opTerm := OpTerm{op: token}
-		getToken()
-		opTerm.rhs = compileTerm()
-		result.opTerms = append(result.opTerms, opTerm)
+// compileExpression parses terms joined by operators, which Jack applies
+// from left to right, into BinaryExpressions leaning to the left. With
+// operator precedence, operators that bind tighter are applied first.
+func (p *Parser) compileExpression() ast.Expression {
+	return p.compileOperands(0)
+}
+
+// compileOperands parses the terms joined by operators that bind at least
+// as tightly as minPrecedence.
+func (p *Parser) compileOperands(minPrecedence int) ast.Expression {
+	result := p.compileTerm()
+	for p.isOp() && p.opPrecedence() >= minPrecedence {
+		binary := &ast.BinaryExpression{X: result, Op: p.token}
+		precedence := p.opPrecedence()
+		p.getToken()
+		binary.Y = p.compileOperands(precedence + 1)
+		result = binary
 	}
 	return result
 }
 
-func compileArgumentList() []Expression {
-	var result []Expression
-	for IsTerm() {
-		expression := compileExpression()
+// opPrecedence returns the precedence of the current token, an operator.
+// Without operator precedence all operators are alike.
+func (p *Parser) opPrecedence() int {
+	if !p.withPrecedence {
+		return 0
+	}
+	return ast.Precedence(p.token.Value)
+}
+
+func (p *Parser) compileArgumentList() []ast.Expression {
+	var result []ast.Expression
+	for p.isTerm() {
+		expression := p.compileExpression()
 		result = append(result, expression)
-		if token.value == "," {
-			getToken()
-			continue
-		} else {
+		if p.token.Value != "," {
 			break
 		}
+		p.getToken()
+		p.expect(p.isTerm(), "expression")
 	}
 	return result
 }
@@ -0,0 +1,544 @@
+package parser
+
+import (
+	"encoding/json"
+	"io"
+	"jack"
+	"strconv"
+
+	"jack/JackC/ast"
+	"jack/JackC/symbols"
+)
+
+// The JSON form of a class tree, written by OutputJSON and read by
+// ReadJSON, is made of the objects below. Every token has the line and the
+// columns where it is in the Jack source, which ReadJSON keeps for error
+// messages and source maps; identifiers that name variables also have the
+// symbol they stand for, which ReadJSON ignores and defines again from the
+// declarations. Lists that are empty may be left out.
+//
+//	{
+//	  "file": "Main.jack",
+//	  "name": {"type": "identifier", "value": "Main", "line": 1, "column": 7, "endColumn": 11},
+//	  "classVarDecs": [...],
+//	  "subroutines": [...]
+//	}
+
+// jsonToken is a token, such as
+// {"type": "identifier", "value": "x", "line": 3, "column": 9, "endColumn": 10}.
+// Type is keyword, symbol, identifier, integerConstant or stringConstant.
+type jsonToken struct {
+	Type      string      `json:"type"`
+	Value     string      `json:"value"`
+	Line      int         `json:"line,omitempty"`
+	Column    int         `json:"column,omitempty"`
+	EndColumn int         `json:"endColumn,omitempty"`
+	Symbol    *jsonSymbol `json:"symbol,omitempty"`
+}
+
+// jsonSymbol is the variable an identifier names: its kind, static, field,
+// argument or var, its type and its index among the variables of its kind.
+type jsonSymbol struct {
+	Kind  string `json:"kind"`
+	Type  string `json:"type"`
+	Index int    `json:"index"`
+}
+
+type jsonClass struct {
+	File         string           `json:"file"`
+	Name         *jsonToken       `json:"name"`
+	ClassVarDecs []jsonVarDec     `json:"classVarDecs,omitempty"`
+	Subroutines  []jsonSubroutine `json:"subroutines,omitempty"`
+}
+
+// jsonVarDec declares variables: Kind is static or field for the variables
+// of a class and left out for those of a subroutine.
+type jsonVarDec struct {
+	Kind  *jsonToken  `json:"kind,omitempty"`
+	Type  *jsonToken  `json:"type"`
+	Names []jsonToken `json:"names"`
+}
+
+type jsonParameter struct {
+	Type *jsonToken `json:"type"`
+	Name *jsonToken `json:"name"`
+}
+
+// jsonSubroutine is a subroutine; Kind is constructor, function or method.
+type jsonSubroutine struct {
+	Kind       *jsonToken      `json:"kind"`
+	ReturnType *jsonToken      `json:"returnType"`
+	Name       *jsonToken      `json:"name"`
+	Parameters []jsonParameter `json:"parameters,omitempty"`
+	VarDecs    []jsonVarDec    `json:"varDecs,omitempty"`
+	Statements []jsonStatement `json:"statements,omitempty"`
+}
+
+// jsonStatement is a statement; Statement is let, if, while, do or return
+// and says which of the other fields it has besides its Keyword:
+//
+//	let     variable, index if it is an array element, value
+//	if      condition, then, else if it has an else clause
+//	while   condition, body
+//	do      call
+//	return  value unless it returns nothing
+type jsonStatement struct {
+	Statement string           `json:"statement"`
+	Keyword   *jsonToken       `json:"keyword,omitempty"`
+	Variable  *jsonToken       `json:"variable,omitempty"`
+	Index     *jsonExpression  `json:"index,omitempty"`
+	Value     *jsonExpression  `json:"value,omitempty"`
+	Condition *jsonExpression  `json:"condition,omitempty"`
+	Then      []jsonStatement  `json:"then,omitempty"`
+	Else      *[]jsonStatement `json:"else,omitempty"`
+	Body      []jsonStatement  `json:"body,omitempty"`
+	Call      *jsonCall        `json:"call,omitempty"`
+}
+
+// jsonExpression is a term followed by operators and terms, which Jack
+// applies from left to right.
+type jsonExpression struct {
+	Term *jsonTerm    `json:"term"`
+	Ops  []jsonOpTerm `json:"ops,omitempty"`
+}
+
+type jsonOpTerm struct {
+	Op   *jsonToken `json:"op"`
+	Term *jsonTerm  `json:"term"`
+}
+
+// jsonTerm is a term; Term is one of the following and says which of the
+// other fields it has:
+//
+//	constant       token, an integer or string constant or true, false, null or this
+//	variable       token
+//	unary          op, - or ~, and operand
+//	parenthesized  expression
+//	arrayAccess    variable, index
+//	call           call
+type jsonTerm struct {
+	Term       string          `json:"term"`
+	Token      *jsonToken      `json:"token,omitempty"`
+	Op         *jsonToken      `json:"op,omitempty"`
+	Operand    *jsonTerm       `json:"operand,omitempty"`
+	Expression *jsonExpression `json:"expression,omitempty"`
+	Variable   *jsonToken      `json:"variable,omitempty"`
+	Index      *jsonExpression `json:"index,omitempty"`
+	Call       *jsonCall       `json:"call,omitempty"`
+}
+
+// jsonCall is a call of subroutine Name, of the class or object Receiver if
+// there is one.
+type jsonCall struct {
+	Receiver  *jsonToken       `json:"receiver,omitempty"`
+	Name      *jsonToken       `json:"name"`
+	Arguments []jsonExpression `json:"arguments,omitempty"`
+}
+
+// OutputJSON writes the class tree as JSON, see jsonClass.
+func OutputJSON(tree *ast.Class, wr io.Writer) {
+	jw := jsonWriter{class: tree}
+	class := jsonClass{File: tree.Name.Filename, Name: jw.token(tree.Name)}
+	for _, dec := range tree.Vars {
+		class.ClassVarDecs = append(class.ClassVarDecs, jsonVarDec{
+			Kind: jw.token(dec.Kind), Type: jw.token(dec.Type), Names: jw.variables(dec.Names)})
+	}
+	for _, dec := range tree.Subroutines {
+		class.Subroutines = append(class.Subroutines, jw.subroutine(dec))
+	}
+	data, err := json.MarshalIndent(class, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	wr.Write(append(data, '\n'))
+}
+
+// jsonWriter turns a class tree into its JSON form.
+type jsonWriter struct {
+	class *ast.Class
+	dec   *ast.SubroutineDec // the subroutine being written, if any
+}
+
+func (jw *jsonWriter) token(t Token) *jsonToken {
+	return &jsonToken{Type: t.Type, Value: t.Value, Line: t.Line, Column: t.Column, EndColumn: t.EndColumn}
+}
+
+// variable returns the token of an identifier that may name a variable,
+// with the symbol it stands for if it does.
+func (jw *jsonWriter) variable(t Token) *jsonToken {
+	result := jw.token(t)
+	symbol := lookupSymbol(jw.class, jw.dec, t.Value)
+	if symbol.Exists() {
+		result.Symbol = &jsonSymbol{Kind: symbol.KindOf().String(), Type: symbol.TypeOf().String(), Index: symbol.IndexOf()}
+	}
+	return result
+}
+
+func (jw *jsonWriter) variables(ts []Token) []jsonToken {
+	result := make([]jsonToken, len(ts))
+	for i, t := range ts {
+		result[i] = *jw.variable(t)
+	}
+	return result
+}
+
+func (jw *jsonWriter) subroutine(dec *ast.SubroutineDec) jsonSubroutine {
+	jw.dec = dec
+	result := jsonSubroutine{Kind: jw.token(dec.Kind), ReturnType: jw.token(dec.ReturnType), Name: jw.token(dec.Name)}
+	for _, parm := range dec.Parameters {
+		result.Parameters = append(result.Parameters, jsonParameter{Type: jw.token(parm.Type), Name: jw.variable(parm.Name)})
+	}
+	for _, varDec := range dec.Vars {
+		result.VarDecs = append(result.VarDecs, jsonVarDec{Type: jw.token(varDec.Type), Names: jw.variables(varDec.Names)})
+	}
+	result.Statements = jw.statements(dec.Statements)
+	return result
+}
+
+func (jw *jsonWriter) statements(stmts []ast.Statement) []jsonStatement {
+	// an empty else clause is [], not null
+	result := []jsonStatement{}
+	for _, stmt := range stmts {
+		var js jsonStatement
+		switch statement := stmt.(type) {
+		case *ast.LetStatement:
+			js = jsonStatement{Statement: "let", Keyword: jw.token(statement.Keyword)}
+			js.Variable = jw.variable(statement.Name)
+			if statement.Index != nil {
+				js.Index = jw.expression(statement.Index)
+			}
+			js.Value = jw.expression(statement.Value)
+		case *ast.IfStatement:
+			js = jsonStatement{Statement: "if", Keyword: jw.token(statement.Keyword)}
+			js.Condition = jw.expression(statement.Condition)
+			js.Then = jw.statements(statement.Then)
+			if statement.HasElse {
+				elseClause := jw.statements(statement.Else)
+				js.Else = &elseClause
+			}
+		case *ast.WhileStatement:
+			js = jsonStatement{Statement: "while", Keyword: jw.token(statement.Keyword)}
+			js.Condition = jw.expression(statement.Condition)
+			js.Body = jw.statements(statement.Body)
+		case *ast.DoStatement:
+			js = jsonStatement{Statement: "do", Keyword: jw.token(statement.Keyword)}
+			js.Call = jw.call(statement.Call)
+		case *ast.ReturnStatement:
+			js = jsonStatement{Statement: "return", Keyword: jw.token(statement.Keyword)}
+			if statement.Value != nil {
+				js.Value = jw.expression(statement.Value)
+			}
+		}
+		result = append(result, js)
+	}
+	return result
+}
+
+// expression flattens the BinaryExpressions on the left of expr into a term
+// followed by operators and terms.
+func (jw *jsonWriter) expression(expr ast.Expression) *jsonExpression {
+	binary, ok := expr.(*ast.BinaryExpression)
+	if !ok {
+		return &jsonExpression{Term: jw.term(expr)}
+	}
+	result := jw.expression(binary.X)
+	result.Ops = append(result.Ops, jsonOpTerm{Op: jw.token(binary.Op), Term: jw.term(binary.Y)})
+	return result
+}
+
+func (jw *jsonWriter) term(term ast.Expression) *jsonTerm {
+	switch actualTerm := term.(type) {
+	case *ast.Constant:
+		return &jsonTerm{Term: "constant", Token: jw.token(actualTerm.Token)}
+	case *ast.Variable:
+		return &jsonTerm{Term: "variable", Token: jw.variable(actualTerm.Name)}
+	case *ast.UnaryExpression:
+		return &jsonTerm{Term: "unary", Op: jw.token(actualTerm.Op), Operand: jw.term(actualTerm.X)}
+	case *ast.ParenExpression:
+		return &jsonTerm{Term: "parenthesized", Expression: jw.expression(actualTerm.X)}
+	case *ast.IndexExpression:
+		return &jsonTerm{Term: "arrayAccess", Variable: jw.variable(actualTerm.Name), Index: jw.expression(actualTerm.Index)}
+	case *ast.Call:
+		return &jsonTerm{Term: "call", Call: jw.call(actualTerm)}
+	}
+	// the right operand of an operator of a class parsed with operator
+	// precedence, which is applied first as if it were in parentheses
+	return &jsonTerm{Term: "parenthesized", Expression: jw.expression(term)}
+}
+
+func (jw *jsonWriter) call(call *ast.Call) *jsonCall {
+	result := &jsonCall{Name: jw.token(call.Name), Arguments: jw.expressions(call.Arguments)}
+	if call.Receiver != nil {
+		result.Receiver = jw.variable(*call.Receiver)
+	}
+	return result
+}
+
+func (jw *jsonWriter) expressions(exprs []ast.Expression) []jsonExpression {
+	var result []jsonExpression
+	for _, expr := range exprs {
+		result = append(result, *jw.expression(expr))
+	}
+	return result
+}
+
+// ReadJSON reads the JSON form of a class tree, see jsonClass, from r,
+// which is called name in error messages. The tokens keep the file of the
+// Jack source. It fails with the *jack.Diagnostics of what is missing or
+// wrong if the tree is not one the parser could have built.
+func ReadJSON(r io.Reader, name string) (*ast.Class, error) {
+	var class jsonClass
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&class)
+	if err != nil {
+		return nil, err
+	}
+	jr := jsonReader{filename: class.File, near: jack.Span{Filename: name}}
+	if jr.filename == "" {
+		jr.filename = name
+	}
+	tree := jr.class(class)
+	if jr.diagnostics.Errors() > 0 {
+		return tree, &jr.diagnostics
+	}
+	return tree, nil
+}
+
+// jsonReader turns the JSON form of a class tree back into a tree, with its
+// symbol tables.
+type jsonReader struct {
+	filename         string
+	classSymbolTable symbols.SymbolTable
+	// near is where the last token read was, where errors are reported
+	near        jack.Span
+	diagnostics jack.Diagnostics
+}
+
+func isTokenType(tokenType string) func(Token) bool {
+	return func(t Token) bool { return t.Type == tokenType }
+}
+
+func isOneOf(values ...string) func(Token) bool {
+	return func(t Token) bool {
+		for _, value := range values {
+			if t.Value == value && (t.Type == "keyword" || t.Type == "symbol") {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// isVarType reports whether t is the type of a variable.
+func isVarType(t Token) bool {
+	return t.Type == "identifier" || isOneOf("int", "char", "boolean")(t)
+}
+
+// token returns the token of jt, which must be there and be valid for what
+// it is, what.
+func (jr *jsonReader) token(jt *jsonToken, what string, valid func(Token) bool) Token {
+	if jt == nil {
+		jr.diagnostics.Errorf(jr.near, "missing %s", what)
+		return Token{}
+	}
+	t := Token{Type: jt.Type, Value: jt.Value,
+		Pos: ast.Pos{Filename: jr.filename, Line: jt.Line, Column: jt.Column, EndColumn: jt.EndColumn}}
+	if jt.Line > 0 {
+		jr.near = t.Span()
+	}
+	if !valid(t) {
+		jr.diagnostics.Errorf(jr.near, "%s %q is not a valid %s", jt.Type, jt.Value, what)
+	}
+	return t
+}
+
+func (jr *jsonReader) tokens(jts []jsonToken, what string, valid func(Token) bool) []Token {
+	if len(jts) == 0 {
+		jr.diagnostics.Errorf(jr.near, "missing %s", what)
+	}
+	var result []Token
+	for i := range jts {
+		result = append(result, jr.token(&jts[i], what, valid))
+	}
+	return result
+}
+
+func (jr *jsonReader) class(class jsonClass) *ast.Class {
+	tree := &ast.Class{Keyword: Token{Type: "keyword", Value: "class", Pos: ast.Pos{Filename: jr.filename}}}
+	tree.Name = jr.token(class.Name, "class name", isTokenType("identifier"))
+	tree.Symbols = symbols.NewSymbolTable(tree.Name.Value)
+	jr.classSymbolTable = tree.Symbols
+	for _, jd := range class.ClassVarDecs {
+		dec := &ast.ClassVarDec{
+			Kind: jr.token(jd.Kind, "class variable kind", isOneOf("static", "field")),
+			Type: jr.token(jd.Type, "variable type", isVarType),
+		}
+		dec.Names = jr.tokens(jd.Names, "variable name", isTokenType("identifier"))
+		kind := symbols.FIELD
+		if dec.Kind.Value == "static" {
+			kind = symbols.STATIC
+		}
+		for _, name := range dec.Names {
+			jr.classSymbolTable.Define(name.Value, dec.Type.Value, kind)
+		}
+		tree.Vars = append(tree.Vars, dec)
+	}
+	for _, js := range class.Subroutines {
+		tree.Subroutines = append(tree.Subroutines, jr.subroutine(js))
+	}
+	return tree
+}
+
+func (jr *jsonReader) subroutine(js jsonSubroutine) *ast.SubroutineDec {
+	dec := &ast.SubroutineDec{}
+	dec.Kind = jr.token(js.Kind, "subroutine kind", isOneOf("constructor", "function", "method"))
+	dec.ReturnType = jr.token(js.ReturnType, "return type", func(t Token) bool { return isVarType(t) || isOneOf("void")(t) })
+	dec.Name = jr.token(js.Name, "subroutine name", isTokenType("identifier"))
+	dec.Symbols = symbols.NewSymbolTable(dec.Name.Value)
+	if dec.Kind.Value == "method" {
+		dec.Symbols.Define("this", jr.classSymbolTable.Name, symbols.ARGUMENT)
+	}
+	for _, jp := range js.Parameters {
+		parm := &ast.Parameter{Type: jr.token(jp.Type, "parameter type", isVarType),
+			Name: jr.token(jp.Name, "parameter name", isTokenType("identifier"))}
+		dec.Symbols.Define(parm.Name.Value, parm.Type.Value, symbols.ARGUMENT)
+		dec.Parameters = append(dec.Parameters, parm)
+	}
+	for _, jd := range js.VarDecs {
+		varDec := &ast.VarDec{Keyword: Token{Type: "keyword", Value: "var", Pos: ast.Pos{Filename: jr.filename}},
+			Type: jr.token(jd.Type, "variable type", isVarType)}
+		varDec.Names = jr.tokens(jd.Names, "variable name", isTokenType("identifier"))
+		for _, name := range varDec.Names {
+			dec.Symbols.Define(name.Value, varDec.Type.Value, symbols.VAR)
+		}
+		dec.Vars = append(dec.Vars, varDec)
+	}
+	dec.Statements = jr.statements(js.Statements)
+	return dec
+}
+
+func (jr *jsonReader) statements(jss []jsonStatement) []ast.Statement {
+	var result []ast.Statement
+	for _, js := range jss {
+		keyword := Token{Type: "keyword", Value: js.Statement, Pos: ast.Pos{Filename: jr.filename}}
+		if js.Keyword != nil {
+			valid := isOneOf(js.Statement)
+			if !isStatementKeyword(js.Statement) {
+				// reported below as an invalid statement
+				valid = func(Token) bool { return true }
+			}
+			keyword = jr.token(js.Keyword, js.Statement+" keyword", valid)
+		}
+		switch js.Statement {
+		case "let":
+			let := &ast.LetStatement{Keyword: keyword, Name: jr.token(js.Variable, "variable", isTokenType("identifier"))}
+			if js.Index != nil {
+				let.Index = jr.expression(js.Index, "index")
+			}
+			let.Value = jr.expression(js.Value, "value")
+			result = append(result, let)
+		case "if":
+			ifStatement := &ast.IfStatement{Keyword: keyword, Condition: jr.expression(js.Condition, "condition"),
+				Then: jr.statements(js.Then)}
+			if js.Else != nil {
+				ifStatement.HasElse = true
+				ifStatement.Else = jr.statements(*js.Else)
+			}
+			result = append(result, ifStatement)
+		case "while":
+			result = append(result, &ast.WhileStatement{Keyword: keyword, Condition: jr.expression(js.Condition, "condition"),
+				Body: jr.statements(js.Body)})
+		case "do":
+			result = append(result, &ast.DoStatement{Keyword: keyword, Call: jr.call(js.Call)})
+		case "return":
+			returnStatement := &ast.ReturnStatement{Keyword: keyword}
+			if js.Value != nil {
+				returnStatement.Value = jr.expression(js.Value, "value")
+			}
+			result = append(result, returnStatement)
+		default:
+			jr.diagnostics.Errorf(jr.near, "invalid statement %q", js.Statement)
+		}
+	}
+	return result
+}
+
+func isStatementKeyword(value string) bool {
+	return value == "let" || value == "if" || value == "while" || value == "do" || value == "return"
+}
+
+// expression turns the operators and terms of je into BinaryExpressions
+// leaning to the left.
+func (jr *jsonReader) expression(je *jsonExpression, what string) ast.Expression {
+	if je == nil {
+		jr.diagnostics.Errorf(jr.near, "missing %s", what)
+		return &ast.Constant{}
+	}
+	expr := jr.term(je.Term)
+	for _, jo := range je.Ops {
+		binary := &ast.BinaryExpression{X: expr, Op: jr.token(jo.Op, "operator", isOneOf("+", "-", "*", "/", "&", "|", "<", ">", "="))}
+		binary.Y = jr.term(jo.Term)
+		expr = binary
+	}
+	return expr
+}
+
+func isConstant(t Token) bool {
+	switch t.Type {
+	case "integerConstant":
+		n, err := strconv.Atoi(t.Value)
+		return err == nil && n >= 0 && n <= maxInt
+	case "stringConstant":
+		return true
+	}
+	return isOneOf("true", "false", "null", "this")(t)
+}
+
+func (jr *jsonReader) term(jt *jsonTerm) ast.Expression {
+	if jt == nil {
+		jr.diagnostics.Errorf(jr.near, "missing term")
+		return &ast.Constant{}
+	}
+	switch jt.Term {
+	case "constant":
+		return &ast.Constant{Token: jr.token(jt.Token, "constant", isConstant)}
+	case "variable":
+		return &ast.Variable{Name: jr.token(jt.Token, "variable", isTokenType("identifier"))}
+	case "unary":
+		unary := &ast.UnaryExpression{Op: jr.token(jt.Op, "unary operator", isOneOf("-", "~"))}
+		unary.X = jr.term(jt.Operand)
+		return unary
+	case "parenthesized":
+		// the parenthesis is not in the JSON form, it is put where the
+		// expression starts
+		paren := &ast.ParenExpression{X: jr.expression(jt.Expression, "expression")}
+		paren.Lparen = Token{Type: "symbol", Value: "(", Pos: paren.X.Pos()}
+		return paren
+	case "arrayAccess":
+		access := &ast.IndexExpression{Name: jr.token(jt.Variable, "variable", isTokenType("identifier"))}
+		access.Index = jr.expression(jt.Index, "index")
+		return access
+	case "call":
+		return jr.call(jt.Call)
+	}
+	jr.diagnostics.Errorf(jr.near, "invalid term %q", jt.Term)
+	return &ast.Constant{}
+}
+
+func (jr *jsonReader) call(jc *jsonCall) *ast.Call {
+	if jc == nil {
+		jr.diagnostics.Errorf(jr.near, "missing call")
+		return &ast.Call{}
+	}
+	call := &ast.Call{}
+	if jc.Receiver != nil {
+		receiver := jr.token(jc.Receiver, "class or variable name", isTokenType("identifier"))
+		call.Receiver = &receiver
+	}
+	call.Name = jr.token(jc.Name, "subroutine name", isTokenType("identifier"))
+	for i := range jc.Arguments {
+		call.Arguments = append(call.Arguments, jr.expression(&jc.Arguments[i], "argument"))
+	}
+	return call
+}
@@ -1,339 +1,283 @@
 package parser
 
 import (
-	"bytes"
 	"fmt"
-	"html"
 	"io"
-	"jack/JackC/symbols"
-)
+	"strings"
 
-var writer io.WriteCloser
-var spacesToIndent int = 0
+	"jack/JackC/ast"
+)
 
-func indent() {
-	var buf bytes.Buffer
-	for i := 0; i < spacesToIndent; i++ {
-		buf.WriteString(" ")
-	}
-	writer.Write(buf.Bytes())
+// xmlWriter writes the parse tree or the tokens of a class as XML in the
+// format of the reference Jack analyzer: an element per line, indented by
+// two spaces for every level, with lines ending in "\r\n".
+type xmlWriter struct {
+	writer         io.Writer
+	spacesToIndent int
 }
 
-func write(format string, a ...interface{}) {
-	outString := fmt.Sprintf(format, a...) + "\r\n"
-	io.WriteString(writer, outString)
-}
+// xmlEscaper escapes what the reference analyzer escapes.
+var xmlEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\"", "&quot;")
 
-func writeOpenTag(tag string) {
-	indent()
-	write("<%s>", tag)
-	spacesToIndent += 2
+func (xw *xmlWriter) write(format string, a ...interface{}) {
+	outString := strings.Repeat(" ", xw.spacesToIndent) + fmt.Sprintf(format, a...) + "\r\n"
+	io.WriteString(xw.writer, outString)
 }
 
-func writeCloseTag(tag string) {
-	spacesToIndent -= 2
-	indent()
-	write("</%s>", tag)
+func (xw *xmlWriter) writeOpenTag(tag string) {
+	xw.write("<%s>", tag)
+	xw.spacesToIndent += 2
 }
 
-func writeToken(token Token) {
-	indent()
-	val := html.EscapeString(token.value)
-	write("<%s> %s </%s>", token.tokenType, val, token.tokenType)
+func (xw *xmlWriter) writeCloseTag(tag string) {
+	xw.spacesToIndent -= 2
+	xw.write("</%s>", tag)
 }
 
-func writeTagValue(tag, value string) {
-	indent()
-	write("<%s> %s </%s>", tag, value, tag)
+func (xw *xmlWriter) writeToken(token Token) {
+	xw.write("<%s> %s </%s>", token.Type, xmlEscaper.Replace(token.Value), token.Type)
 }
 
-func writeKeyword(value string) {
-	indent()
-	write("<keyword> %s </keyword>", value)
+func (xw *xmlWriter) writeKeyword(value string) {
+	xw.write("<keyword> %s </keyword>", value)
 }
 
-func writeSymbol(value string) {
-	indent()
-	write("<symbol> %s </symbol>", html.EscapeString(value))
+func (xw *xmlWriter) writeSymbol(value string) {
+	xw.write("<symbol> %s </symbol>", xmlEscaper.Replace(value))
 }
 
-func outputClass(tree ClassTree, wr io.WriteCloser) {
-	writer = wr
-	writeOpenTag("class")
-	writeKeyword("class")
-	writeClassName(tree.className.value)
-	writeSymbol("{")
-	writeClassVarDecs(tree.classVarDecs)
-	writeSubroutineDecs(tree.subroutineDecs)
-	writeSymbol("}")
-	writeCloseTag("class")
+// OutputXML writes the parse tree of the class tree as XML, as in Foo.xml.
+func OutputXML(tree *ast.Class, wr io.Writer) {
+	xw := &xmlWriter{writer: wr}
+	xw.writeOpenTag("class")
+	xw.writeKeyword("class")
+	xw.writeToken(tree.Name)
+	xw.writeSymbol("{")
+	xw.writeClassVarDecs(tree.Vars)
+	xw.writeSubroutineDecs(tree.Subroutines)
+	xw.writeSymbol("}")
+	xw.writeCloseTag("class")
 }
 
-func writeSubroutineDecs(decs []SubroutineDec) {
-	for _, dec := range decs {
-		writeOpenTag("subroutineDec")
-		writeToken(dec.ctrOrFuncOrMethod)
-		writeType(dec.returnType)
-		writeElement("subroutine-name", dec.name.value)
-		writeSymbol("(")
-		writeOpenTag("parameterList")
-		writeParameters(dec)
-		writeCloseTag("parameterList")
-		writeSymbol(")")
-		writeSubroutineBody(dec.body)
-		writeCloseTag("subroutineDec")
+// OutputTokens writes the tokens of the class tree as XML, as in FooT.xml.
+func OutputTokens(tree *ast.Class, wr io.Writer) {
+	xw := &xmlWriter{writer: wr}
+	xw.write("<tokens>")
+	for _, token := range tree.Tokens {
+		if token.Type != "eof" {
+			xw.writeToken(token)
+		}
 	}
+	xw.write("</tokens>")
 }
 
-func writeSubroutineBody(body SubroutineBody) {
-	writeOpenTag("subroutineBody")
-	writeSymbol("{")
-	for _, varDec := range body.varDecs {
-		writeOpenTag("varDec")
-		writeKeyword("var")
-		writeToken(varDec.varType)
-		for i, name := range varDec.names {
-			writeToken(name)
-			if i < len(varDec.names)-1 {
-				writeSymbol(",")
-			}
-		}
-		writeSymbol(";")
-		writeCloseTag("varDec")
+func (xw *xmlWriter) writeClassVarDecs(decs []*ast.ClassVarDec) {
+	for _, dec := range decs {
+		xw.writeOpenTag("classVarDec")
+		xw.writeToken(dec.Kind)
+		xw.writeToken(dec.Type)
+		xw.writeNames(dec.Names)
+		xw.writeSymbol(";")
+		xw.writeCloseTag("classVarDec")
 	}
-	writeStatements(body.statements)
-	writeSymbol("}")
-	writeCloseTag("subroutineBody")
 }
 
-func writeStatements(stmts []Statement) {
-	writeOpenTag("statements")
-	for _, stmt := range stmts {
-		switch statement := stmt.stmt.(type) {
-		case LetStatement:
-			writeLetStatement(statement)
-		case IfStatement:
-			writeIfStatement(statement)
-		case WhileStatement:
-			writeWhileStatement(statement)
-		case DoStatement:
-			writeDoStatement(statement)
-		case ReturnStatement:
-			writeReturnStatement(statement)
+// writeNames writes the names of a declaration, separated by commas.
+func (xw *xmlWriter) writeNames(names []Token) {
+	for i, name := range names {
+		if i > 0 {
+			xw.writeSymbol(",")
 		}
+		xw.writeToken(name)
 	}
-	writeCloseTag("statements")
 }
 
-func writeReturnStatement(statement ReturnStatement) {
-	writeOpenTag("returnStatement")
-	writeKeyword("return")
-	if statement.returnExpression.term.term != nil {
-		writeExpression(statement.returnExpression)
+func (xw *xmlWriter) writeSubroutineDecs(decs []*ast.SubroutineDec) {
+	for _, dec := range decs {
+		xw.writeOpenTag("subroutineDec")
+		xw.writeToken(dec.Kind)
+		xw.writeToken(dec.ReturnType)
+		xw.writeToken(dec.Name)
+		xw.writeSymbol("(")
+		xw.writeOpenTag("parameterList")
+		for i, parm := range dec.Parameters {
+			if i > 0 {
+				xw.writeSymbol(",")
+			}
+			xw.writeToken(parm.Type)
+			xw.writeToken(parm.Name)
+		}
+		xw.writeCloseTag("parameterList")
+		xw.writeSymbol(")")
+		xw.writeSubroutineBody(dec)
+		xw.writeCloseTag("subroutineDec")
 	}
-	writeSymbol(";")
-	writeCloseTag("returnStatement")
-}
-
-func writeDoStatement(statement DoStatement) {
-	writeOpenTag("doStatement")
-	writeKeyword("do")
-	writeSubroutineCall(statement.subroutineCall)
-	writeCloseTag("doStatement")
 }
 
-func writeSubroutineCall(subroutineCall interface{}) {
-	switch call := subroutineCall.(type) {
-	case FunctionCall:
-		writeFunctionCall(call)
-	case MethodCall:
-		writeMethodCall(call)
+func (xw *xmlWriter) writeSubroutineBody(dec *ast.SubroutineDec) {
+	xw.writeOpenTag("subroutineBody")
+	xw.writeSymbol("{")
+	for _, varDec := range dec.Vars {
+		xw.writeOpenTag("varDec")
+		xw.writeKeyword("var")
+		xw.writeToken(varDec.Type)
+		xw.writeNames(varDec.Names)
+		xw.writeSymbol(";")
+		xw.writeCloseTag("varDec")
 	}
+	xw.writeStatements(dec.Statements)
+	xw.writeSymbol("}")
+	xw.writeCloseTag("subroutineBody")
 }
 
-func writeExpressionList(expressions []Expression) {
-	writeOpenTag("expressionList")
-	for i, expr := range expressions {
-		writeExpression(expr)
-		if i < len(expressions)-1 {
-			writeSymbol(",")
+func (xw *xmlWriter) writeStatements(stmts []ast.Statement) {
+	xw.writeOpenTag("statements")
+	for _, stmt := range stmts {
+		switch statement := stmt.(type) {
+		case *ast.LetStatement:
+			xw.writeLetStatement(statement)
+		case *ast.IfStatement:
+			xw.writeIfStatement(statement)
+		case *ast.WhileStatement:
+			xw.writeWhileStatement(statement)
+		case *ast.DoStatement:
+			xw.writeDoStatement(statement)
+		case *ast.ReturnStatement:
+			xw.writeReturnStatement(statement)
 		}
 	}
-	writeCloseTag("expressionList")
+	xw.writeCloseTag("statements")
 }
 
-func writeMethodCall(call MethodCall) {
-	writeToken(call.classOrVarName)
-	writeSymbol(".")
-	writeToken(call.methodName)
-	writeSymbol("(")
-	writeExpressionList(call.arguments)
-	writeSymbol(")")
-	writeSymbol(";")
-}
-
-func writeFunctionCall(call FunctionCall) {
-	writeToken(call.functionName)
-	writeSymbol("(")
-	writeExpressionList(call.arguments)
-	writeSymbol(")")
-	writeSymbol(";")
-}
-
-func writeWhileStatement(statement WhileStatement) {
-	writeOpenTag("whileStatement")
-	writeKeyword("while")
-	writeSymbol("(")
-	writeExpression(statement.condition)
-	writeSymbol(")")
-	writeSymbol("{")
-	writeStatements(statement.stmts)
-	writeSymbol("}")
-	writeCloseTag("whileStatement")
-}
-
-func writeIfStatement(stmt IfStatement) {
-	writeOpenTag("ifStatement")
-	writeKeyword("if")
-	writeSymbol("(")
-	writeExpression(stmt.condition)
-	writeSymbol(")")
-	writeSymbol("{")
-	writeStatements(stmt.thenClause)
-	writeSymbol("}")
-	if stmt.isElse {
-		writeKeyword("else")
-		writeSymbol("{")
-		writeStatements(stmt.elseClause)
-		writeSymbol("}")
-	}
-	writeCloseTag("ifStatement")
-}
-
-func writeLetStatement(stmt LetStatement) {
-	writeOpenTag("letStatement")
-	writeKeyword("let")
-	writeToken(stmt.varName)
-	if stmt.isArray {
-		writeSymbol("[")
-		writeExpression(stmt.indexExpression)
-		writeSymbol("]")
+func (xw *xmlWriter) writeReturnStatement(statement *ast.ReturnStatement) {
+	xw.writeOpenTag("returnStatement")
+	xw.writeKeyword("return")
+	if statement.Value != nil {
+		xw.writeExpression(statement.Value)
 	}
-	writeSymbol("=")
-	writeExpression(stmt.rhs)
-	writeSymbol(";")
-	writeCloseTag("letStatement")
+	xw.writeSymbol(";")
+	xw.writeCloseTag("returnStatement")
 }
 
-func writeExpression(expr Expression) {
-	writeOpenTag("expression")
-	//io.WriteString(writer, "  some expression  \r\n")
-	writeTerm(expr.term)
-	for _, opterm := range expr.opTerms {
-		writeToken(opterm.op)
-		writeTerm(opterm.rhs)
-	}
-	writeCloseTag("expression")
+func (xw *xmlWriter) writeDoStatement(statement *ast.DoStatement) {
+	xw.writeOpenTag("doStatement")
+	xw.writeKeyword("do")
+	xw.writeSubroutineCall(statement.Call)
+	xw.writeSymbol(";")
+	xw.writeCloseTag("doStatement")
 }
 
-func writeTerm(term Term) {
-	writeOpenTag("term")
-	switch actualTerm := term.term.(type) {
-	case SingleTokenTerm:
-		writeToken(actualTerm.value)
-	case UnaryOpTerm:
-		writeToken(actualTerm.unaryOp)
-		writeTerm(actualTerm.term)
-	case Expression:
-		writeSymbol("(")
-		writeExpression(actualTerm)
-		writeSymbol(")")
-	case ArrayAccessTerm:
-		writeToken(actualTerm.varName)
-		writeSymbol("[")
-		writeExpression(actualTerm.index)
-		writeSymbol("]")
-	case FunctionCall:
-		writeToken(actualTerm.functionName)
-		writeSymbol("(")
-		writeArguments(actualTerm.arguments)
-		writeSymbol(")")
-	case MethodCall:
-		writeToken(actualTerm.classOrVarName)
-		writeSymbol(".")
-		writeToken(actualTerm.methodName)
-		writeSymbol("(")
-		writeArguments(actualTerm.arguments)
-		writeSymbol(")")
+// writeSubroutineCall writes the tokens of a call, which has no element of
+// its own.
+func (xw *xmlWriter) writeSubroutineCall(call *ast.Call) {
+	if call.Receiver != nil {
+		xw.writeToken(*call.Receiver)
+		xw.writeSymbol(".")
 	}
-	writeCloseTag("term")
+	xw.writeToken(call.Name)
+	xw.writeArguments(call.Arguments)
 }
 
-func writeArguments(args []Expression) {
-	writeOpenTag("expressionList")
+func (xw *xmlWriter) writeArguments(args []ast.Expression) {
+	xw.writeSymbol("(")
+	xw.writeOpenTag("expressionList")
 	for i, arg := range args {
-		writeExpression(arg)
-		if i < len(args)-1 {
-			writeSymbol(",")
+		if i > 0 {
+			xw.writeSymbol(",")
 		}
+		xw.writeExpression(arg)
 	}
-	writeCloseTag("expressionList")
-}
-
-func writeClassVarDecs(decs []ClassVarDec) {
-	for _, dec := range decs {
-		writeOpenTag("classVarDec")
-		writeToken(dec.staticOrField)
-		writeToken(dec.varType)
-		writeClassVarDec(dec)
-		writeSymbol(";")
-		writeCloseTag("classVarDec")
-	}
+	xw.writeCloseTag("expressionList")
+	xw.writeSymbol(")")
 }
 
-func writeElement(tag, contents string) {
-	indent()
-	val := html.EscapeString(contents)
-	write("<%s> %s </%s>", tag, val, tag)
+func (xw *xmlWriter) writeWhileStatement(statement *ast.WhileStatement) {
+	xw.writeOpenTag("whileStatement")
+	xw.writeKeyword("while")
+	xw.writeSymbol("(")
+	xw.writeExpression(statement.Condition)
+	xw.writeSymbol(")")
+	xw.writeSymbol("{")
+	xw.writeStatements(statement.Body)
+	xw.writeSymbol("}")
+	xw.writeCloseTag("whileStatement")
 }
 
-func writeClassVarDec(dec ClassVarDec) {
-	writeOpenTag(dec.staticOrField.value)
-	for _, name := range dec.varNames {
-		writeDefinition(name.value, classSymbolTable)
+func (xw *xmlWriter) writeIfStatement(stmt *ast.IfStatement) {
+	xw.writeOpenTag("ifStatement")
+	xw.writeKeyword("if")
+	xw.writeSymbol("(")
+	xw.writeExpression(stmt.Condition)
+	xw.writeSymbol(")")
+	xw.writeSymbol("{")
+	xw.writeStatements(stmt.Then)
+	xw.writeSymbol("}")
+	if stmt.HasElse {
+		xw.writeKeyword("else")
+		xw.writeSymbol("{")
+		xw.writeStatements(stmt.Else)
+		xw.writeSymbol("}")
 	}
-	writeCloseTag(dec.staticOrField.value)
+	xw.writeCloseTag("ifStatement")
 }
 
-func writeDefinition(name string, symbolTable symbols.SymbolTable) {
-	symbol := symbolTable.Lookup(name)
-	writeElement("name", name)
-	writeElement("reference-type", "definition")
-	writeElement("access", fmt.Sprintf("%v %d", symbol.KindOf(), symbol.IndexOf()))
+func (xw *xmlWriter) writeLetStatement(stmt *ast.LetStatement) {
+	xw.writeOpenTag("letStatement")
+	xw.writeKeyword("let")
+	xw.writeToken(stmt.Name)
+	if stmt.Index != nil {
+		xw.writeSymbol("[")
+		xw.writeExpression(stmt.Index)
+		xw.writeSymbol("]")
+	}
+	xw.writeSymbol("=")
+	xw.writeExpression(stmt.Value)
+	xw.writeSymbol(";")
+	xw.writeCloseTag("letStatement")
 }
 
-func writeClassName(className string) {
-	writeElement("class-name", className)
+// writeExpression writes an expression as a flat list of terms and
+// operators, as the reference analyzer does.
+func (xw *xmlWriter) writeExpression(expr ast.Expression) {
+	xw.writeOpenTag("expression")
+	xw.writeOpTerms(expr)
+	xw.writeCloseTag("expression")
 }
 
-func writeType(token Token) {
-	writeOpenTag("type")
-	if token.tokenType == "identifier" {
-		writeElement("class", token.value)
-	} else {
-		writeToken(token)
+// writeOpTerms writes the terms and operators of expr in the order of the
+// source, whichever way its BinaryExpressions lean.
+func (xw *xmlWriter) writeOpTerms(expr ast.Expression) {
+	binary, ok := expr.(*ast.BinaryExpression)
+	if !ok {
+		xw.writeTerm(expr)
+		return
 	}
-	writeCloseTag("type")
+	xw.writeOpTerms(binary.X)
+	xw.writeToken(binary.Op)
+	xw.writeOpTerms(binary.Y)
 }
 
-func writeParameters(dec SubroutineDec) {
-	for i, parm := range dec.parameters {
-		writeType(parm.parmType)
-		writeOpenTag("argument")
-		writeDefinition(parm.name.value, dec.symbolTable)
-		writeCloseTag("argument")
-		if i < len(dec.parameters)-1 {
-			writeSymbol(",")
-		}
+func (xw *xmlWriter) writeTerm(term ast.Expression) {
+	xw.writeOpenTag("term")
+	switch actualTerm := term.(type) {
+	case *ast.Constant:
+		xw.writeToken(actualTerm.Token)
+	case *ast.Variable:
+		xw.writeToken(actualTerm.Name)
+	case *ast.UnaryExpression:
+		xw.writeToken(actualTerm.Op)
+		xw.writeTerm(actualTerm.X)
+	case *ast.ParenExpression:
+		xw.writeSymbol("(")
+		xw.writeExpression(actualTerm.X)
+		xw.writeSymbol(")")
+	case *ast.IndexExpression:
+		xw.writeToken(actualTerm.Name)
+		xw.writeSymbol("[")
+		xw.writeExpression(actualTerm.Index)
+		xw.writeSymbol("]")
+	case *ast.Call:
+		xw.writeSubroutineCall(actualTerm)
 	}
+	xw.writeCloseTag("term")
 }
@@ -3,316 +3,315 @@ package parser
 import (
 	"fmt"
 	"io"
+	"jack"
+	"jack/JackC/ast"
 	"jack/JackC/symbols"
+	"sort"
 	"strconv"
 )
 
 type VmWriter struct {
-	writer             io.WriteCloser
-	classTree          ClassTree
-	currentSymbolTable *symbols.SymbolTable
-	currentSubroutine  *SubroutineDec
-	labelGenerator     LabelGenerator
+	compiler          *Compiler
+	writer            io.Writer
+	classTree         *ast.Class
+	currentSubroutine *ast.SubroutineDec
+	labelGenerator    LabelGenerator
+	// line is the number of lines written, position the Jack line they are
+	// currently generated from and sourceMap where that is recorded
+	line      int
+	position  jack.Position
+	sourceMap *jack.SourceMap
 }
 
-func (vw VmWriter) outputStaticVariables() {
-	prt("// static variables")
-	for _, dec := range vw.classTree.classVarDecs {
-		if dec.staticOrField.value != "static" {
+func (vw *VmWriter) outputStaticVariables() {
+	vw.prt("// static variables")
+	for _, dec := range vw.classTree.Vars {
+		if dec.Kind.Value != "static" {
 			continue
 		}
-		for _, varName := range dec.varNames {
-			name := varName.value
+		for _, varName := range dec.Names {
+			name := varName.Value
 			symbol := vw.Lookup(name)
-			prt("//  name:%s, access:%s", name, symbol.Access())
+			vw.prt("//  name:%s, access:%s", name, symbol.Access())
 		}
 	}
 }
 
-func (vw VmWriter) outputFieldVariables() {
-	prt("// fields")
-	for _, dec := range vw.classTree.classVarDecs {
-		if dec.staticOrField.value != "field" {
+func (vw *VmWriter) outputFieldVariables() {
+	vw.prt("// fields")
+	for _, dec := range vw.classTree.Vars {
+		if dec.Kind.Value != "field" {
 			continue
 		}
-		for _, varName := range dec.varNames {
-			name := varName.value
+		for _, varName := range dec.Names {
+			name := varName.Value
 			symbol := vw.Lookup(name)
-			prt("//  name:%s, access:%s", name, symbol.Access())
+			vw.prt("//  name:%s, access:%s", name, symbol.Access())
 		}
 	}
 }
 
-func (vw VmWriter) printSymbolTable(symbolTable symbols.SymbolTable) {
+func (vw *VmWriter) printSymbolTable(symbolTable symbols.SymbolTable) {
 	if len(symbolTable.Map) == 0 {
 		return
 	}
-	prt("//symbols")
-	for name, symbol := range symbolTable.Map {
-		prt("//  name:%s, type:%v, kind:%v, index:%d", name, symbol.TypeOf(), symbol.KindOf(), symbol.IndexOf())
+	vw.prt("//symbols")
+	var names []string
+	for name := range symbolTable.Map {
+		names = append(names, name)
+	}
+	// list symbols by kind and index rather than in map order
+	sort.Slice(names, func(i, j int) bool {
+		a, b := symbolTable.Map[names[i]], symbolTable.Map[names[j]]
+		if a.KindOf() != b.KindOf() {
+			return a.KindOf() < b.KindOf()
+		}
+		return a.IndexOf() < b.IndexOf()
+	})
+	for _, name := range names {
+		symbol := symbolTable.Map[name]
+		vw.prt("//  name:%s, type:%v, kind:%v, index:%d", name, symbol.TypeOf(), symbol.KindOf(), symbol.IndexOf())
 	}
 }
 
-func (vw VmWriter) evaluateTerm(t Term) {
-	switch term := t.term.(type) {
-	case UnaryOpTerm:
-		vw.evaluateUnaryOpTerm(term)
-	case SingleTokenTerm:
-		vw.evaluateSingleTokenTerm(term)
-	case Expression:
-		vw.evaluateExpression(term)
-	case ArrayAccessTerm:
-		vw.evaluateArrayAccessTerm(term)
-	case FunctionCall:
-		vw.outputFunctionCall(term)
-	case MethodCall:
-		vw.outputMethodCall(term)
+func (vw *VmWriter) evaluateExpression(expr ast.Expression) {
+	switch expr := expr.(type) {
+	case *ast.UnaryExpression:
+		vw.evaluateUnaryExpression(expr)
+	case *ast.Constant:
+		vw.evaluateConstant(expr.Token)
+	case *ast.Variable:
+		symbol := vw.Lookup(expr.Name.Value)
+		vw.prt("push %s", symbol.Access())
+	case *ast.BinaryExpression:
+		vw.evaluateExpression(expr.X)
+		vw.evaluateExpression(expr.Y)
+		vw.evaluateOp(expr.Op.Value)
+	case *ast.ParenExpression:
+		vw.evaluateExpression(expr.X)
+	case *ast.IndexExpression:
+		vw.evaluateIndexExpression(expr)
+	case *ast.Call:
+		vw.outputCall(expr)
 	default:
-		prt("panic: unknown type of Term: %v", t)
+		vw.prt("panic: unknown type of expression: %T", expr)
 	}
 }
 
-func (vw VmWriter) evaluateUnaryOpTerm(term UnaryOpTerm) {
-	vw.evaluateTerm(term.term)
-	vw.evaluateUnaryOp(term.unaryOp)
+func (vw *VmWriter) evaluateUnaryExpression(expr *ast.UnaryExpression) {
+	vw.evaluateExpression(expr.X)
+	vw.evaluateUnaryOp(expr.Op)
 }
 
-func (vw VmWriter) evaluateUnaryOp(unaryOp Token) {
-	switch unaryOp.value {
+func (vw *VmWriter) evaluateUnaryOp(unaryOp Token) {
+	switch unaryOp.Value {
 	case "+":
 		// do nothing
 	case "-":
-		prt("neg")
+		vw.prt("neg")
 	case "~":
-		prt("not")
+		vw.prt("not")
 	}
 }
 
-func (vw VmWriter) evaluateSingleTokenTerm(term SingleTokenTerm) {
-	token := term.value
-	if token.tokenType == "keyword" {
-		switch token.value {
+func (vw *VmWriter) evaluateConstant(token Token) {
+	if token.Type == "keyword" {
+		switch token.Value {
 		case "true":
-			prt("push constant 1")
-			prt("neg")
+			vw.prt("push constant 1")
+			vw.prt("neg")
 		case "false", "null":
-			prt("push constant 0")
+			vw.prt("push constant 0")
 		case "this":
-			prt("push pointer 0")
+			vw.prt("push pointer 0")
 		}
 		return
 	}
-	if token.tokenType == "stringConstant" {
-		bytes := []byte(token.value)
-		prt("push constant %d", len(bytes))
-		prt("call String.new 1")
+	if token.Type == "stringConstant" {
+		bytes := []byte(token.Value)
+		vw.prt("push constant %d", len(bytes))
+		vw.prt("call String.new 1")
 		for _, b := range bytes {
-			prt("push constant %d", b)
-			prt("call String.appendChar 2")
+			vw.prt("push constant %d", b)
+			vw.prt("call String.appendChar 2")
 		}
 		return
 	}
-	if token.tokenType == "integerConstant" {
-		num, _ := strconv.Atoi(token.value)
-		prt("push constant %d", num)
-		return
-	}
-	if token.tokenType == "identifier" {
-		symbol := vw.Lookup(token.value)
-		prt("push %s", symbol.Access())
+	if token.Type == "integerConstant" {
+		num, _ := strconv.Atoi(token.Value)
+		vw.prt("push constant %d", num)
 		return
 	}
-	prt("panic: evaluateSingleTokenTerm unhandled token type:%s token value:%s", token.tokenType, token.value)
+	vw.prt("panic: evaluateConstant unhandled token type:%s token value:%s", token.Type, token.Value)
 }
 
-func (vw VmWriter) evaluateArrayAccessTerm(term ArrayAccessTerm) {
-	symbol := vw.Lookup(term.varName.value)
-	prt("push %s", symbol.Access())
-	vw.evaluateExpression(term.index)
-	prt("add")
-	prt("pop pointer 1")
-	prt("push that 0")
+func (vw *VmWriter) evaluateIndexExpression(expr *ast.IndexExpression) {
+	symbol := vw.Lookup(expr.Name.Value)
+	vw.prt("push %s", symbol.Access())
+	vw.evaluateExpression(expr.Index)
+	vw.prt("add")
+	vw.prt("pop pointer 1")
+	vw.prt("push that 0")
 }
 
-func (vw VmWriter) evaluateExpression(expr Expression) {
-	vw.evaluateTerm(expr.term)
-	for _, opterm := range expr.opTerms {
-		vw.evaluateTerm(opterm.rhs)
-		vw.evaluateOp(opterm.op.value)
-	}
-}
-
-func (vw VmWriter) evaluateOp(op string) {
+func (vw *VmWriter) evaluateOp(op string) {
 	switch op {
 	case "+":
-		prt("add")
+		vw.prt("add")
 	case "-":
-		prt("sub")
+		vw.prt("sub")
 	case "*":
-		prt("call Math.multiply 2")
+		vw.prt("call Math.multiply 2")
 	case "/":
-		prt("call Math.divide 2")
+		vw.prt("call Math.divide 2")
 	case "&":
-		prt("and")
+		vw.prt("and")
 	case "|":
-		prt("or")
+		vw.prt("or")
 	case "<":
-		prt("lt")
+		vw.prt("lt")
 	case ">":
-		prt("gt")
+		vw.prt("gt")
 	case "=":
-		prt("eq")
+		vw.prt("eq")
 	}
 }
 
-func (vw VmWriter) outputLetStatement(statement LetStatement) {
-	name := statement.varName.value
+func (vw *VmWriter) outputLetStatement(statement *ast.LetStatement) {
+	name := statement.Name.Value
 	var symbol symbols.Symbol
 	symbol = vw.Lookup(name)
-	if statement.isArray {
-		vw.evaluateExpression(statement.rhs)
-		prt("push %s", symbol.Access()) // push address of array
-		vw.evaluateExpression(statement.indexExpression)
-		prt("add")
-		prt("pop pointer 1")
-		prt("pop that 0")
+	if statement.Index != nil {
+		vw.evaluateExpression(statement.Value)
+		vw.prt("push %s", symbol.Access()) // push address of array
+		vw.evaluateExpression(statement.Index)
+		vw.prt("add")
+		vw.prt("pop pointer 1")
+		vw.prt("pop that 0")
 	} else {
-		vw.evaluateExpression(statement.rhs)
-		prt("pop %s", symbol.Access())
+		vw.evaluateExpression(statement.Value)
+		vw.prt("pop %s", symbol.Access())
 	}
 
 }
 
-func (vw VmWriter) outputIfStatement(statement IfStatement) {
+func (vw *VmWriter) outputIfStatement(statement *ast.IfStatement) {
 	l1 := vw.labelGenerator.generateLabel("IF")
-	vw.evaluateExpression(statement.condition)
-	prt("not")
-	prt("if-goto %s", l1) // skip to 'else' clause, if exists, otherwise skip to end of statement
-	vw.outputStatements(statement.thenClause)
-	if statement.isElse {
+	vw.evaluateExpression(statement.Condition)
+	vw.prt("not")
+	vw.prt("if-goto %s", l1) // skip to 'else' clause, if exists, otherwise skip to end of statement
+	vw.outputStatements(statement.Then)
+	if statement.HasElse {
 		l2 := vw.labelGenerator.generateLabel("IF")
-		prt("goto %s", l2) // at end of 'then' clause, skip over the 'else' clause
-		prt("label %s", l1)
-		vw.outputStatements(statement.elseClause)
-		prt("label %s", l2)
+		vw.prt("goto %s", l2) // at end of 'then' clause, skip over the 'else' clause
+		vw.prt("label %s", l1)
+		vw.outputStatements(statement.Else)
+		vw.prt("label %s", l2)
 	} else {
-		prt("label %s", l1)
+		vw.prt("label %s", l1)
 	}
 }
 
-func (vw VmWriter) outputWhileStatement(statement WhileStatement) {
+func (vw *VmWriter) outputWhileStatement(statement *ast.WhileStatement) {
 	l1 := vw.labelGenerator.generateLabel("WHILE")
 	l2 := vw.labelGenerator.generateLabel("WHILE")
-	prt("label %s", l1)
-	vw.evaluateExpression(statement.condition)
-	prt("not")
-	prt("if-goto %s", l2)
-	vw.outputStatements(statement.stmts)
-	prt("goto %s", l1)
-	prt("label %s", l2)
+	vw.prt("label %s", l1)
+	vw.evaluateExpression(statement.Condition)
+	vw.prt("not")
+	vw.prt("if-goto %s", l2)
+	vw.outputStatements(statement.Body)
+	vw.prt("goto %s", l1)
+	vw.prt("label %s", l2)
 }
 
-func (vw VmWriter) outputDoStatement(statement DoStatement) {
-	switch call := statement.subroutineCall.(type) {
-	case FunctionCall:
-		vw.outputFunctionCall(call)
-	case MethodCall:
-		vw.outputMethodCall(call)
-	}
-	prt("pop temp 0")
+func (vw *VmWriter) outputDoStatement(statement *ast.DoStatement) {
+	vw.outputCall(statement.Call)
+	vw.prt("pop temp 0")
 }
 
-func (vw VmWriter) outputReturnStatement(statement ReturnStatement) {
-	if vw.currentSubroutine.returnType.value == "void" {
-		prt("push constant 0")
-	} else if vw.currentSubroutine.ctrOrFuncOrMethod.value == "constructor" {
-		prt("push pointer 0")
-	} else if statement.isEmpty {
-		prt("push constant 0")
+func (vw *VmWriter) outputReturnStatement(statement *ast.ReturnStatement) {
+	if vw.currentSubroutine.ReturnType.Value == "void" {
+		vw.prt("push constant 0")
+	} else if vw.currentSubroutine.Kind.Value == "constructor" {
+		vw.prt("push pointer 0")
+	} else if statement.Value == nil {
+		vw.prt("push constant 0")
 	} else {
-		vw.evaluateExpression(statement.returnExpression)
+		vw.evaluateExpression(statement.Value)
 	}
-	prt("return")
+	vw.prt("return")
 }
 
-func (vw VmWriter) outputFunctionCall(call FunctionCall) {
-	prt("push pointer 0")
-	for i, argExp := range call.arguments {
-		prt("// push value of arg %d", i)
-		vw.evaluateExpression(argExp)
+// outputCall writes a call as resolveCall resolves it: the object of a method
+// call first, then the arguments.
+func (vw *VmWriter) outputCall(call *ast.Call) {
+	resolved, err := vw.compiler.resolveCall(vw.classTree, vw.currentSubroutine, call)
+	if err != nil {
+		panic(err)
 	}
-	prt("call %s.%s %d", vw.classTree.className.value, call.functionName.value, len(call.arguments)+1)
-}
-
-func (vw VmWriter) outputMethodCall(call MethodCall) {
-	classOrVarName := call.classOrVarName.value
-	symbol := vw.Lookup(classOrVarName)
-	if symbol.Exists() {
-		// this is a genuine method call
-		prt("push %s", symbol.Access())
+	if resolved.object != "" {
+		vw.prt("push %s", resolved.object)
 	}
-	for i, argExp := range call.arguments {
-		prt("// push value of arg %d", i)
+	for i, argExp := range call.Arguments {
+		vw.prt("// push value of arg %d", i)
 		vw.evaluateExpression(argExp)
 	}
-	if symbol.Exists() {
-		prt("call %v.%s %d", symbol.TypeOf(), call.methodName.value, len(call.arguments)+1)
-	} else {
-		// calling function in some other class
-		prt("call %s.%s %d", call.classOrVarName.value, call.methodName.value, len(call.arguments))
-	}
+	vw.prt("call %s %d", resolved.function, resolved.nArgs)
 }
 
-func (vw VmWriter) outputStatements(statements []Statement) {
+func (vw *VmWriter) outputStatements(statements []ast.Statement) {
 	for _, statement := range statements {
 		vw.outputStatement(statement)
 	}
 }
 
-func (vw VmWriter) outputStatement(stmt Statement) {
-	switch statement := stmt.stmt.(type) {
-	case LetStatement:
+func (vw *VmWriter) outputStatement(stmt ast.Statement) {
+	previous := vw.setPosition(stmt.Pos())
+	// code after nested statements comes from the enclosing one again
+	defer func() { vw.position = previous }()
+	switch statement := stmt.(type) {
+	case *ast.LetStatement:
 		vw.outputLetStatement(statement)
-	case IfStatement:
+	case *ast.IfStatement:
 		vw.outputIfStatement(statement)
-	case WhileStatement:
+	case *ast.WhileStatement:
 		vw.outputWhileStatement(statement)
-	case DoStatement:
+	case *ast.DoStatement:
 		vw.outputDoStatement(statement)
-	case ReturnStatement:
+	case *ast.ReturnStatement:
 		vw.outputReturnStatement(statement)
 	}
 }
 
-func (vw VmWriter) outputMethod(dec SubroutineDec) {
-	prt("// set 'this' pointer")
-	prt("push argument 0")
-	prt("pop pointer 0")
-	vw.outputStatements(dec.body.statements)
+func (vw *VmWriter) outputMethod(dec *ast.SubroutineDec) {
+	vw.prt("// set 'this' pointer")
+	vw.prt("push argument 0")
+	vw.prt("pop pointer 0")
+	vw.outputStatements(dec.Statements)
 }
 
-func (vw VmWriter) outputFunction(dec SubroutineDec) {
-	vw.outputStatements(dec.body.statements)
+func (vw *VmWriter) outputFunction(dec *ast.SubroutineDec) {
+	vw.outputStatements(dec.Statements)
 }
 
-func (vw VmWriter) outputConstructor(dec SubroutineDec) {
-	numFields := vw.classTree.symbolTable.VarCount(symbols.FIELD)
-	prt("push constant %d", numFields)
-	prt("call Memory.alloc 1")
-	prt("pop pointer 0")
-	vw.outputStatements(dec.body.statements)
+func (vw *VmWriter) outputConstructor(dec *ast.SubroutineDec) {
+	numFields := vw.classTree.Symbols.VarCount(symbols.FIELD)
+	vw.prt("push constant %d", numFields)
+	vw.prt("call Memory.alloc 1")
+	vw.prt("pop pointer 0")
+	vw.outputStatements(dec.Statements)
 }
 
-func (vw VmWriter) outputSubroutine(dec SubroutineDec) {
-	vw.currentSymbolTable = &dec.symbolTable
-	vw.currentSubroutine = &dec
-	name := fmt.Sprintf("%s.%s", vw.classTree.className.value, dec.name.value)
-	numLocalVariables := dec.symbolTable.VarCount(symbols.VAR)
-	prt("function %s %d", name, numLocalVariables)
-	prt("//type of subroutine: %s", dec.ctrOrFuncOrMethod.value)
-	prt("//returns %s", dec.returnType.value)
-	vw.printSymbolTable(dec.symbolTable)
-	switch dec.ctrOrFuncOrMethod.value {
+func (vw *VmWriter) outputSubroutine(dec *ast.SubroutineDec) {
+	vw.currentSubroutine = dec
+	name := fmt.Sprintf("%s.%s", vw.classTree.Name.Value, dec.Name.Value)
+	vw.setPosition(dec.Name.Pos)
+	numLocalVariables := dec.Symbols.VarCount(symbols.VAR)
+	vw.prt("function %s %d", name, numLocalVariables)
+	vw.prt("//type of subroutine: %s", dec.Kind.Value)
+	vw.prt("//returns %s", dec.ReturnType.Value)
+	vw.printSymbolTable(dec.Symbols)
+	switch dec.Kind.Value {
 	case "method":
 		vw.outputMethod(dec)
 	case "function":
@@ -323,28 +322,24 @@ func (vw VmWriter) outputSubroutine(dec SubroutineDec) {
 
 }
 
-func (vw VmWriter) outputSubroutines() {
-	for _, dec := range vw.classTree.subroutineDecs {
+func (vw *VmWriter) outputSubroutines() {
+	for _, dec := range vw.classTree.Subroutines {
 		vw.outputSubroutine(dec)
 	}
 }
 
-func outputJackVM(tree ClassTree, wrt io.WriteCloser) {
-	vw := VmWriter{writer: wrt, classTree: tree}
+// OutputClass writes the VM code of a class that Check has accepted to wrt.
+// The returned map leads from the lines of the VM code to the Jack lines
+// they were generated from. Several classes can be written in parallel.
+func (c *Compiler) OutputClass(tree *ast.Class, wrt io.Writer) *jack.SourceMap {
+	vw := &VmWriter{compiler: c, writer: wrt, classTree: tree, sourceMap: &jack.SourceMap{}}
 	vw.labelGenerator = newLabelGenerator()
-	setPrtOutput(wrt)
 	vw.outputStaticVariables()
 	vw.outputFieldVariables()
 	vw.outputSubroutines()
+	return vw.sourceMap
 }
 
-func (vw VmWriter) Lookup(name string) symbols.Symbol {
-	result := symbols.NoSymbol()
-	if vw.currentSymbolTable != nil {
-		result = vw.currentSymbolTable.Lookup(name)
-	}
-	if !result.Exists() {
-		result = vw.classTree.symbolTable.Lookup(name)
-	}
-	return result
+func (vw *VmWriter) Lookup(name string) symbols.Symbol {
+	return lookupSymbol(vw.classTree, vw.currentSubroutine, name)
 }
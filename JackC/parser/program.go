@@ -0,0 +1,118 @@
+package parser
+
+import (
+	"bytes"
+	"io"
+	"jack"
+	"sync"
+
+	"jack/JackC/ast"
+)
+
+// Compiler compiles the classes of a Jack program: Parse parses each class,
+// Check checks them all against each other and OutputClass writes the VM
+// code of each. Parse and OutputClass may be called in parallel.
+type Compiler struct {
+	typeCheck  Strictness
+	precedence bool
+	// subroutineTable holds the subroutines of every class of the program
+	// and of the OS, by class name and subroutine name
+	subroutineTable map[string]map[string]*ast.SubroutineDec
+	diagnostics     *jack.Diagnostics
+}
+
+func NewCompiler() *Compiler {
+	return &Compiler{diagnostics: &jack.Diagnostics{}}
+}
+
+// Diagnostics returns the errors and warnings found by the last Check. A
+// Check that succeeds may still have found warnings to report.
+func (c *Compiler) Diagnostics() *jack.Diagnostics {
+	return c.diagnostics
+}
+
+// SetPrecedence makes Parse parse every class with operator precedence, see
+// Parser.SetPrecedence.
+func (c *Compiler) SetPrecedence(on bool) {
+	c.precedence = on
+}
+
+// Parse parses the class read from r, which is called name in error
+// messages, with a Parser of its own.
+func (c *Compiler) Parse(r io.Reader, name string) (*ast.Class, error) {
+	var p Parser
+	p.SetPrecedence(c.precedence)
+	return p.Parse(r, name)
+}
+
+// ParseSources parses the classes of sources in parallel. If there are
+// syntax errors it fails with the *jack.Diagnostics of them all, those of
+// each file in the order of sources.
+func (c *Compiler) ParseSources(sources []jack.Source) ([]*ast.Class, error) {
+	classes := make([]*ast.Class, len(sources))
+	errs := make([]error, len(sources))
+	var wg sync.WaitGroup
+	for i, src := range sources {
+		wg.Add(1)
+		go func(i int, src jack.Source) {
+			defer wg.Done()
+			r, err := jack.Open(src.Filename)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			defer r.Close()
+			classes[i], errs[i] = c.Parse(r, src.Filename)
+		}(i, src)
+	}
+	wg.Wait()
+	var diagnostics jack.Diagnostics
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+		fileDiagnostics, ok := err.(*jack.Diagnostics)
+		if !ok {
+			return nil, err
+		}
+		diagnostics.Append(fileDiagnostics)
+	}
+	if diagnostics.Errors() > 0 {
+		return nil, &diagnostics
+	}
+	return classes, nil
+}
+
+// OutputClasses writes the VM code of classes in parallel and returns the
+// code of each with its source map, see OutputClass.
+func (c *Compiler) OutputClasses(classes []*ast.Class) ([][]byte, []*jack.SourceMap) {
+	codes := make([][]byte, len(classes))
+	maps := make([]*jack.SourceMap, len(classes))
+	var wg sync.WaitGroup
+	for i, class := range classes {
+		wg.Add(1)
+		go func(i int, class *ast.Class) {
+			defer wg.Done()
+			var buf bytes.Buffer
+			maps[i] = c.OutputClass(class, &buf)
+			codes[i] = buf.Bytes()
+		}(i, class)
+	}
+	wg.Wait()
+	return codes, maps
+}
+
+// Compile compiles a program of the single class read from r to VM code
+// written to w, and returns the map from its lines to those of the class.
+// It fails with the *jack.Diagnostics of Parse or Check.
+func (c *Compiler) Compile(r io.Reader, name string, w io.Writer) (*jack.SourceMap, error) {
+	class, err := c.Parse(r, name)
+	if err != nil {
+		return nil, err
+	}
+	err = c.Check([]*ast.Class{class})
+	if err != nil {
+		return nil, err
+	}
+	return c.OutputClass(class, w), nil
+}
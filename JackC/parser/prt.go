@@ -3,15 +3,21 @@ package parser
 import (
 	"fmt"
 	"io"
+	"jack"
+	"jack/JackC/ast"
 )
 
-var prtWriter io.Writer
-
-func setPrtOutput(wrt io.Writer) {
-	prtWriter = wrt
+// setPosition makes the following lines come from the line of pos, and
+// returns the position they came from before.
+func (vw *VmWriter) setPosition(pos ast.Pos) jack.Position {
+	previous := vw.position
+	vw.position = jack.Position{Filename: pos.Filename, Line: pos.Line}
+	return previous
 }
 
-func prt(format string, a ...interface{}) {
+func (vw *VmWriter) prt(format string, a ...interface{}) {
 	outString := fmt.Sprintf(format, a...) + "\r\n"
-	io.WriteString(prtWriter, outString)
+	io.WriteString(vw.writer, outString)
+	vw.line++
+	vw.sourceMap.Add(vw.line, vw.position)
 }
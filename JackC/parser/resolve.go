@@ -0,0 +1,92 @@
+package parser
+
+import (
+	"fmt"
+
+	"jack/JackC/ast"
+	"jack/JackC/symbols"
+)
+
+// resolvedCall is a subroutine call as the VM code makes it.
+type resolvedCall struct {
+	object   string // segment and index of the object a method is called on, "" for other calls
+	function string // Class.subroutine
+	nArgs    int    // arguments of the VM call, including the object
+	external bool   // a function of a class that is not known, which is left to the linker
+	callee   *ast.SubroutineDec
+}
+
+// resolveCall finds out what call, made in the subroutine dec of class,
+// calls and how.
+func (c *Compiler) resolveCall(class *ast.Class, dec *ast.SubroutineDec, call *ast.Call) (resolvedCall, error) {
+	var className, object string
+	var callee *ast.SubroutineDec
+	var ok bool
+	name, nArgs := call.Name.Value, len(call.Arguments)
+	if call.Receiver == nil {
+		className = class.Name.Value
+		callee, ok = c.subroutineTable[className][name]
+		if !ok {
+			return resolvedCall{}, fmt.Errorf("call to undefined subroutine %s.%s", className, name)
+		}
+		if callee.Kind.Value == "method" {
+			if dec.Kind.Value == "function" {
+				return resolvedCall{}, fmt.Errorf("method %s.%s called from function %s.%s", className, name, className, dec.Name.Value)
+			}
+			object = "pointer 0"
+		}
+	} else {
+		receiver := call.Receiver.Value
+		symbol := lookupSymbol(class, dec, receiver)
+		if symbol.Exists() {
+			className = symbol.TypeOf().String()
+			if !symbol.TypeOf().IsClass() {
+				return resolvedCall{}, fmt.Errorf("cannot call %s on %s of type %s, which is not an object", name, receiver, className)
+			}
+			callee, ok = c.subroutineTable[className][name]
+			if !ok {
+				return resolvedCall{}, fmt.Errorf("call to undefined subroutine %s.%s", className, name)
+			}
+			if callee.Kind.Value != "method" {
+				return resolvedCall{}, fmt.Errorf("%s %s.%s called on object %s", callee.Kind.Value, className, name, receiver)
+			}
+			if symbol.KindOf() == symbols.FIELD && dec.Kind.Value == "function" {
+				return resolvedCall{}, fmt.Errorf("field %s used in function %s.%s", receiver, class.Name.Value, dec.Name.Value)
+			}
+			object = symbol.Access()
+		} else {
+			className = receiver
+			if _, ok := c.subroutineTable[className]; !ok {
+				// such as Main.main called by Sys.init when the OS is compiled by itself
+				return resolvedCall{function: className + "." + name, nArgs: nArgs, external: true}, nil
+			}
+			callee, ok = c.subroutineTable[className][name]
+			if !ok {
+				return resolvedCall{}, fmt.Errorf("call to undefined subroutine %s.%s", className, name)
+			}
+			if callee.Kind.Value == "method" {
+				return resolvedCall{}, fmt.Errorf("method %s.%s called without an object", className, name)
+			}
+		}
+	}
+	if len(callee.Parameters) != nArgs {
+		return resolvedCall{}, fmt.Errorf("%s.%s takes %d argument(s), not %d", className, name, len(callee.Parameters), nArgs)
+	}
+	if object != "" {
+		nArgs++
+	}
+	return resolvedCall{object: object, function: className + "." + name, nArgs: nArgs, callee: callee}, nil
+}
+
+// lookupSymbol looks name up in the subroutine dec, if not nil, and then in
+// its class.
+func lookupSymbol(class *ast.Class, dec *ast.SubroutineDec, name string) symbols.Symbol {
+	result := symbols.NoSymbol()
+	if dec != nil {
+		result = dec.Symbols.Lookup(name)
+	}
+	if !result.Exists() {
+		result = class.Symbols.Lookup(name)
+	}
+	return result
+}
@@ -1,8 +1,8 @@
 package parser
 
-type Token struct {
-	tokenType string
-	value     string
-	file      string
-	lineno    int
-}
+import "jack/JackC/ast"
+
+// Token is a token of the scanner, which the tree keeps. Besides the types
+// of ast.Token, the scanner makes "error" tokens for the errors it finds and
+// an "eof" token for the end of the file.
+type Token = ast.Token
@@ -2,15 +2,14 @@ package parser
 
 import (
 	"fmt"
+	"io"
 	"jack"
+	"jack/JackC/ast"
+	"strconv"
 	"strings"
-	"unicode"
 	"unicode/utf8"
 )
 
-var OutputC chan Token
-var filename string
-
 func isSymbol(ch rune) bool {
 	symbols := []rune{'{', '}', '(', ')', '[', ']', '.', ',', ';', '+', '-', '*', '/', '&', '|', '<', '>', '=', '~'}
 	for _, symbol := range symbols {
@@ -32,80 +31,213 @@ func isKeyword(identifier string) bool {
 	return false
 }
 
-var inComment bool = false
+// maxInt is the largest integer constant, the largest number of the Hack
+// computer.
+const maxInt = 32767
+
+// scanner splits the source of a class into tokens. It goes through the
+// source a byte at a time, keeping track of the line and the column.
+type scanner struct {
+	filename  string
+	source    string // token values are substrings of it
+	offset    int    // of the next byte
+	lineno    int
+	lineStart int // the offset of the first byte of the line
+	tokens    []Token
+	comments  []comment
+}
+
+// comment is a comment of the source with the index of the token after it,
+// where the parser attaches it to the tree.
+type comment struct {
+	*ast.Comment
+	before int
+}
+
+// endLine returns the line the comment ends on.
+func (c comment) endLine() int {
+	return c.Line + strings.Count(c.Text, "\n")
+}
+
+// tokenize returns the tokens of source, which is called name in error
+// messages, followed by an "eof" token just after its last line, and its
+// comments. Errors come as "error" tokens where they are found, for the
+// parser to report in order with its own.
+func tokenize(source []byte, name string) ([]Token, []comment) {
+	// Jack code seldom has more than a token for every three bytes, so the
+	// tokens are seldom copied as they are appended
+	s := scanner{filename: name, source: string(source), lineno: 1, tokens: make([]Token, 0, len(source)/3+1)}
+	for s.offset < len(source) {
+		s.scanToken()
+	}
+	// the end of the file is just after its last line, not on the empty
+	// line after its final newline
+	lineno, lastLine := s.lineno, s.source[s.lineStart:]
+	if lineno > 1 && len(lastLine) == 0 {
+		lineno--
+		lastLine = s.source[:len(s.source)-1]
+		lastLine = strings.TrimRight(lastLine[strings.LastIndexByte(lastLine, '\n')+1:], "\r")
+	}
+	return append(s.tokens, Token{Type: "eof", Pos: ast.Pos{Filename: name, Line: lineno, Column: len(lastLine) + 1}}), s.comments
+}
+
+// pos returns the position from offset start to offset end, both on the
+// current line.
+func (s *scanner) pos(start int, end int) ast.Pos {
+	return ast.Pos{Filename: s.filename, Line: s.lineno, Column: start - s.lineStart + 1, EndColumn: end - s.lineStart + 1}
+}
+
+// addToken adds a token from offset start to the current offset.
+func (s *scanner) addToken(tokenType string, value string, start int) {
+	s.tokens = append(s.tokens, Token{Type: tokenType, Value: value, Pos: s.pos(start, s.offset)})
+}
+
+// addError adds an "error" token from offset start to offset end, both on
+// the current line.
+func (s *scanner) addError(start int, end int, format string, a ...interface{}) {
+	s.tokens = append(s.tokens, Token{Type: "error", Value: fmt.Sprintf(format, a...), Pos: s.pos(start, end)})
+}
+
+// addComment adds the comment from offset start to offset end, which may
+// hold newlines, and moves past it. Its position spans its first line.
+func (s *scanner) addComment(start int, end int) {
+	pos := s.pos(start, min(end, s.lineEnd()))
+	s.comments = append(s.comments, comment{&ast.Comment{Text: s.source[start:end], Pos: pos}, len(s.tokens)})
+	s.skip(end - start)
+}
+
+// skip moves past n bytes, which may hold newlines.
+func (s *scanner) skip(n int) {
+	for end := s.offset + n; s.offset < end; s.offset++ {
+		if s.source[s.offset] == '\n' {
+			s.lineno++
+			s.lineStart = s.offset + 1
+		}
+	}
+}
+
+// lineEnd returns the offset of the end of the current line, before its
+// newline.
+func (s *scanner) lineEnd() int {
+	end := strings.IndexByte(s.source[s.offset:], '\n')
+	if end < 0 {
+		return len(s.source)
+	}
+	return len(strings.TrimRight(s.source[:s.offset+end], "\r"))
+}
 
-func tokenizeLine(line string, lineno int, origLine string) error {
-	var endIdx int
+func isLetter(ch byte) bool {
+	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_'
+}
 
-	for len(line) > 0 {
-		line = strings.TrimSpace(line)
-		if strings.HasPrefix(line, "/*") {
-			inComment = true
-			line = line[2:]
+func isDigit(ch byte) bool {
+	return '0' <= ch && ch <= '9'
+}
+
+// scanToken adds the token at the current offset, if any, after the blanks
+// and comments before it.
+func (s *scanner) scanToken() {
+	start := s.offset
+	rest := s.source[start:]
+	ch := rest[0]
+	switch {
+	case ch == ' ' || ch == '\t' || ch == '\r' || ch == '\n' || ch == '\f':
+		s.skip(1)
+	case strings.HasPrefix(rest, "//"):
+		s.addComment(start, s.lineEnd())
+	case strings.HasPrefix(rest, "/*"):
+		end := strings.Index(rest[2:], "*/")
+		if end < 0 {
+			s.addError(start, start+2, "comment without final */")
+			s.skip(len(rest))
+			return
+		}
+		s.addComment(start, start+end+4)
+	case ch == '"':
+		// string constants end on their line
+		end := strings.IndexByte(rest[1:], '"')
+		lineEnd := s.lineEnd()
+		if end < 0 || start+1+end > lineEnd {
+			s.addError(start, lineEnd, "string constant without final quote")
+			s.skip(lineEnd - start)
+			return
+		}
+		s.skip(end + 2)
+		s.addToken("stringConstant", rest[1:end+1], start)
+	case isDigit(ch):
+		for s.offset < len(s.source) && isDigit(s.source[s.offset]) {
+			s.offset++
+		}
+		value := s.source[start:s.offset]
+		if n, err := strconv.Atoi(value); err != nil || n > maxInt {
+			s.addError(start, s.offset, "integer constant %s is too large, the largest is %d", value, maxInt)
 		}
-		if inComment {
-			endIdx = strings.Index(line, "*/")
-			if endIdx >= 0 {
-				inComment = false
-				line = line[endIdx+2:]
-				continue
-			} else {
-				// comment extends past the rest of line, so return
-				return nil
-			}
+		s.addToken("integerConstant", value, start)
+	case isLetter(ch):
+		for s.offset < len(s.source) && (isLetter(s.source[s.offset]) || isDigit(s.source[s.offset])) {
+			s.offset++
 		}
+		value := s.source[start:s.offset]
+		tokenType := "identifier"
+		if isKeyword(value) {
+			tokenType = "keyword"
+		}
+		s.addToken(tokenType, value, start)
+	case isSymbol(rune(ch)):
+		s.offset++
+		s.addToken("symbol", s.source[start:s.offset], start)
+	default:
+		r, width := utf8.DecodeRuneInString(rest)
+		s.offset += width
+		s.addError(start, s.offset, "illegal character %q", r)
+	}
+}
+
+// PrecedencePragma is the comment that makes a class be parsed with operator
+// precedence when it comes before the class.
+const PrecedencePragma = "//jack:precedence"
+
+// SetPrecedence makes Parse parse expressions with operator precedence, see
+// ast.Precedence, instead of applying operators from left to right as Jack
+// does. Without it only the classes with a PrecedencePragma are parsed so.
+func (p *Parser) SetPrecedence(on bool) {
+	p.precedence = on
+}
 
-		firstRune, width := utf8.DecodeRuneInString(line)
-		var arune rune
-		var token Token
-		if isSymbol(firstRune) {
-			token = Token{tokenType: "symbol", value: line[0:1]}
-			line = line[1:]
-		} else if firstRune == '"' {
-			line = line[width:]
-			endIdx = strings.IndexRune(line, '"')
-			if endIdx < 0 {
-				return fmt.Errorf("no final quote found on line %d", lineno)
-			}
-			token = Token{tokenType: "stringConstant", value: line[0:endIdx]}
-			line = line[endIdx+1:]
-		} else if unicode.IsDigit(firstRune) {
-			for endIdx, arune = range line {
-				if unicode.IsDigit(arune) {
-					continue
-				} else {
-					break
-				}
-			}
-			token = Token{tokenType: "integerConstant", value: line[0:endIdx]}
-			line = line[endIdx:]
-		} else if unicode.IsLetter(firstRune) || firstRune == '_' {
-			for endIdx, arune = range line {
-				if unicode.IsLetter(arune) || unicode.IsDigit(arune) || arune == '_' {
-					continue
-				} else {
-					break
-				}
-			}
-			value := line[0:endIdx]
-			tokenType := "identifier"
-			if isKeyword(value) {
-				tokenType = "keyword"
-			}
-			token = Token{tokenType: tokenType, value: value}
-			line = line[endIdx:]
+// hasPrecedencePragma reports whether a PrecedencePragma comes before the
+// first token.
+func (p *Parser) hasPrecedencePragma() bool {
+	first := 0
+	for p.tokens[first].Type == "error" {
+		first++
+	}
+	for _, c := range p.comments {
+		if c.before <= first && strings.TrimSpace(c.Text) == PrecedencePragma {
+			return true
 		}
-		token.file = filename
-		token.lineno = lineno
-		OutputC <- token
 	}
-	return nil
+	return false
 }
 
-func TokenizeFiles(filenames []string) {
-	for _, filename = range filenames {
-		OutputC = make(chan Token)
-		go jack.ForLinesInFile(filename, tokenizeLine)
-		compileFile(OutputC, filename)
+// Parse parses the class read from r, which is called name in error
+// messages. It fails with the *jack.Diagnostics of all syntax errors if
+// there are any. A Parser parses one class at a time, but Parsers of their
+// own can parse several classes in parallel.
+func (p *Parser) Parse(r io.Reader, name string) (*ast.Class, error) {
+	*p = Parser{precedence: p.precedence}
+	source, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	// error messages quote the source, which may not be on disk
+	jack.SetSourceText(name, source)
+	p.tokens, p.comments = tokenize(source, name)
+	p.withPrecedence = p.precedence || p.hasPrecedencePragma()
+	class := p.compileClass()
+	class.Tokens = p.tokens
+	class.Precedence = p.withPrecedence
+	if p.diagnostics.Errors() > 0 {
+		return class, &p.diagnostics
 	}
+	return class, nil
 }
@@ -0,0 +1,83 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type benchmarkSource struct {
+	name   string
+	source []byte
+}
+
+// benchmarkSources returns the Jack sources of the bundled OS, followed by a
+// large class made up of many copies of a subroutine.
+func benchmarkSources(b *testing.B) []benchmarkSource {
+	b.Helper()
+	filenames, err := filepath.Glob("../../VMtranslator/oslib/jack/*.jack")
+	if err != nil || len(filenames) == 0 {
+		b.Fatalf("no OS sources: %v", err)
+	}
+	var sources []benchmarkSource
+	for _, filename := range filenames {
+		source, err := os.ReadFile(filename)
+		if err != nil {
+			b.Fatal(err)
+		}
+		sources = append(sources, benchmarkSource{filepath.Base(filename), source})
+	}
+	return append(sources, benchmarkSource{"Large.jack", largeClass(20000)})
+}
+
+// largeClass returns a class with n subroutines of about 280 bytes each,
+// 5.6 MB for 20000.
+func largeClass(n int) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("class Large {\n    field int count;\n\n")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&buf, `    /** Adds the first n squares to count. */
+    method int f%d(int n) {
+        var int i, sum;
+        let i = 0;
+        while (i < n) {
+            let sum = sum + (i * i);
+            let i = i + 1;
+        }
+        let count = count + sum;
+        return count;
+    }
+
+`, i)
+	}
+	buf.WriteString("}\n")
+	return buf.Bytes()
+}
+
+func BenchmarkTokenize(b *testing.B) {
+	for _, src := range benchmarkSources(b) {
+		b.Run(src.name, func(b *testing.B) {
+			b.SetBytes(int64(len(src.source)))
+			for i := 0; i < b.N; i++ {
+				tokenize(src.source, src.name)
+			}
+		})
+	}
+}
+
+func BenchmarkParse(b *testing.B) {
+	for _, src := range benchmarkSources(b) {
+		b.Run(src.name, func(b *testing.B) {
+			b.SetBytes(int64(len(src.source)))
+			for i := 0; i < b.N; i++ {
+				var p Parser
+				_, err := p.Parse(bytes.NewReader(src.source), src.name)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
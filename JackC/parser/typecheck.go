@@ -0,0 +1,296 @@
+package parser
+
+import "jack/JackC/ast"
+
+// Strictness says how closely Check compares types.
+type Strictness int
+
+const (
+	NoTypeCheck Strictness = iota
+	// Permissive lets int, char and objects, which are all 16 bit words,
+	// stand for each other, as the Jack language does.
+	Permissive
+	// Strict only lets a value be used as its own type, null as any object,
+	// any object as an Array, the generic pointer that Memory.deAlloc takes,
+	// an integer constant as the char of that code and an element of an
+	// Array as anything.
+	Strict
+)
+
+// SetTypeCheck makes Check check the types of the program as well.
+func (c *Compiler) SetTypeCheck(strictness Strictness) {
+	c.typeCheck = strictness
+}
+
+// The type of an expression is the name of a class, int, char, boolean or
+// one of these. An unknown type, such as that of an Array element or an
+// expression with an error already reported, goes with every other type.
+const (
+	unknownType = ""
+	nullType    = "null"
+	voidType    = "void"
+)
+
+func isClassType(t string) bool {
+	switch t {
+	case "int", "char", "boolean", unknownType, nullType, voidType:
+		return false
+	}
+	return true
+}
+
+// isNumber reports whether arithmetic can be done on a value of type t.
+func (tc typeChecker) isNumber(t string) bool {
+	if t == unknownType || t == "int" {
+		return true
+	}
+	return tc.c.typeCheck == Permissive && (t == "char" || t == nullType || isClassType(t))
+}
+
+// assignable reports whether a value of type from can be used as type to.
+func (tc typeChecker) assignable(from, to string) bool {
+	if from == to || from == unknownType || to == unknownType {
+		return true
+	}
+	if from == nullType && isClassType(to) {
+		return true
+	}
+	if to == "Array" && isClassType(from) {
+		return true
+	}
+	return to != "boolean" && from != "boolean" && tc.isNumber(from) && tc.isNumber(to)
+}
+
+// typeChecker checks the types of the subroutine dec of class.
+type typeChecker struct {
+	c     *Compiler
+	class *ast.Class
+	dec   *ast.SubroutineDec
+}
+
+func (c *Compiler) typeCheckSubroutine(class *ast.Class, dec *ast.SubroutineDec) {
+	tc := typeChecker{c: c, class: class, dec: dec}
+	tc.checkStatements(dec.Statements)
+}
+
+func (tc typeChecker) checkStatements(statements []ast.Statement) {
+	for _, stmt := range statements {
+		switch statement := stmt.(type) {
+		case *ast.LetStatement:
+			tc.checkLet(statement)
+		case *ast.IfStatement:
+			tc.checkCondition(statement.Keyword, statement.Condition)
+			tc.checkStatements(statement.Then)
+			tc.checkStatements(statement.Else)
+		case *ast.WhileStatement:
+			tc.checkCondition(statement.Keyword, statement.Condition)
+			tc.checkStatements(statement.Body)
+		case *ast.DoStatement:
+			tc.callType(statement.Call)
+		case *ast.ReturnStatement:
+			tc.checkReturn(statement)
+		}
+	}
+}
+
+func (tc typeChecker) checkLet(statement *ast.LetStatement) {
+	varType := tc.variableType(statement.Name)
+	if statement.Index != nil {
+		tc.checkArray(statement.Name, varType)
+		tc.checkIndex(statement.Index)
+		// the elements of an Array have no type
+		varType = unknownType
+	}
+	rhsType := tc.expressionType(statement.Value)
+	if !tc.fits(statement.Value, rhsType, varType) {
+		tc.c.errorAt(statement.Name.Pos, "cannot assign %s to %s of type %s", rhsType, statement.Name.Value, varType)
+	}
+}
+
+func (tc typeChecker) checkCondition(keyword Token, condition ast.Expression) {
+	t := tc.expressionType(condition)
+	if t != "boolean" && t != unknownType {
+		tc.c.errorAt(keyword.Pos, "condition of %s is %s, not boolean", keyword.Value, t)
+	}
+}
+
+func (tc typeChecker) checkReturn(statement *ast.ReturnStatement) {
+	returnType := tc.dec.ReturnType.Value
+	keyword := statement.Keyword
+	if statement.Value == nil {
+		if returnType != voidType {
+			tc.c.errorAt(keyword.Pos, "%s must return %s", tc.dec.Name.Value, returnType)
+		}
+		return
+	}
+	t := tc.expressionType(statement.Value)
+	if returnType == voidType {
+		tc.c.errorAt(keyword.Pos, "%s returns void, but returns %s here", tc.dec.Name.Value, t)
+	} else if !tc.fits(statement.Value, t, returnType) {
+		tc.c.errorAt(keyword.Pos, "%s must return %s, not %s", tc.dec.Name.Value, returnType, t)
+	}
+}
+
+// checkArray reports a variable that is indexed without being an Array.
+func (tc typeChecker) checkArray(varName Token, varType string) {
+	if varType == "Array" || varType == unknownType {
+		return
+	}
+	if tc.c.typeCheck == Permissive && (varType == "int" || isClassType(varType)) {
+		return
+	}
+	tc.c.errorAt(varName.Pos, "%s of type %s is not an Array", varName.Value, varType)
+}
+
+func (tc typeChecker) checkIndex(index ast.Expression) {
+	t := tc.expressionType(index)
+	if t != "int" && !(tc.c.typeCheck == Permissive && tc.isNumber(t)) && t != unknownType {
+		tc.c.errorAt(index.Pos(), "index of type %s is not an int", t)
+	}
+}
+
+// variableType returns the declared type of a variable, unknownType for an
+// undeclared one.
+func (tc typeChecker) variableType(name Token) string {
+	symbol := lookupSymbol(tc.class, tc.dec, name.Value)
+	if !symbol.Exists() {
+		return unknownType
+	}
+	return symbol.TypeOf().String()
+}
+
+func (tc typeChecker) expressionType(expr ast.Expression) string {
+	switch expr := expr.(type) {
+	case *ast.Constant:
+		token := expr.Token
+		switch token.Type {
+		case "integerConstant":
+			return "int"
+		case "stringConstant":
+			return "String"
+		}
+		switch token.Value {
+		case "true", "false":
+			return "boolean"
+		case "null":
+			return nullType
+		case "this":
+			return tc.class.Name.Value
+		}
+	case *ast.Variable:
+		return tc.variableType(expr.Name)
+	case *ast.UnaryExpression:
+		operand := tc.expressionType(expr.X)
+		if operand == unknownType || (expr.Op.Value == "~" && operand == "boolean") {
+			return operand
+		}
+		if !tc.isNumber(operand) {
+			tc.c.errorAt(expr.Op.Pos, "operand of %s is %s", expr.Op.Value, operand)
+		}
+		return "int"
+	case *ast.BinaryExpression:
+		left := tc.expressionType(expr.X)
+		right := tc.expressionType(expr.Y)
+		switch expr.Op.Value {
+		case "=", "<", ">":
+			// a character code compares with a char
+			if left == "char" && isIntegerConstant(expr.Y) {
+				right = "char"
+			} else if right == "char" && isIntegerConstant(expr.X) {
+				left = "char"
+			}
+		}
+		return tc.operationType(expr.Op, left, right)
+	case *ast.ParenExpression:
+		return tc.expressionType(expr.X)
+	case *ast.IndexExpression:
+		tc.checkArray(expr.Name, tc.variableType(expr.Name))
+		tc.checkIndex(expr.Index)
+		return unknownType
+	case *ast.Call:
+		t := tc.callType(expr)
+		if t == voidType {
+			tc.c.errorAt(expr.Pos(), "the call returns void and has no value")
+			return unknownType
+		}
+		return t
+	}
+	return unknownType
+}
+
+// fits reports whether expr, of type t, can be used as type to.
+func (tc typeChecker) fits(expr ast.Expression, t, to string) bool {
+	if to == "char" && t == "int" && isIntegerConstant(expr) {
+		return true
+	}
+	return tc.assignable(t, to)
+}
+
+// isIntegerConstant reports whether expr is an integer constant, possibly
+// in parentheses.
+func isIntegerConstant(expr ast.Expression) bool {
+	switch expr := expr.(type) {
+	case *ast.Constant:
+		return expr.Token.Type == "integerConstant"
+	case *ast.ParenExpression:
+		return isIntegerConstant(expr.X)
+	}
+	return false
+}
+
+// operationType returns the type of 'left op right'.
+func (tc typeChecker) operationType(op Token, left, right string) string {
+	switch op.Value {
+	case "+", "-", "*", "/":
+		if !tc.isNumber(left) || !tc.isNumber(right) {
+			tc.c.errorAt(op.Pos, "operands of %s are %s and %s, not int", op.Value, left, right)
+		}
+		return "int"
+	case "&", "|":
+		if (left == "boolean" || left == unknownType) && (right == "boolean" || right == unknownType) {
+			if left == unknownType {
+				return right
+			}
+			return left
+		}
+		if left == "boolean" || right == "boolean" || !tc.isNumber(left) || !tc.isNumber(right) {
+			tc.c.errorAt(op.Pos, "operands of %s are %s and %s, not both boolean or both int", op.Value, left, right)
+		}
+		return "int"
+	case "<", ">":
+		if left == "char" && right == "char" {
+			return "boolean"
+		}
+		if !tc.isNumber(left) || !tc.isNumber(right) {
+			tc.c.errorAt(op.Pos, "operands of %s are %s and %s, not int", op.Value, left, right)
+		}
+		return "boolean"
+	case "=":
+		if !tc.assignable(left, right) && !tc.assignable(right, left) {
+			tc.c.errorAt(op.Pos, "cannot compare %s and %s", left, right)
+		}
+		return "boolean"
+	}
+	return unknownType
+}
+
+// callType checks the arguments of a call and returns the type it returns,
+// unknownType if the call cannot be resolved, which Check reports.
+func (tc typeChecker) callType(call *ast.Call) string {
+	var argTypes []string
+	for _, argExp := range call.Arguments {
+		argTypes = append(argTypes, tc.expressionType(argExp))
+	}
+	resolved, err := tc.c.resolveCall(tc.class, tc.dec, call)
+	if err != nil || resolved.external {
+		return unknownType
+	}
+	callee := resolved.callee
+	for i, parm := range callee.Parameters {
+		if !tc.fits(call.Arguments[i], argTypes[i], parm.Type.Value) {
+			tc.c.errorAt(call.Arguments[i].Pos(), "argument %s of %s is %s, not %s",
+				parm.Name.Value, resolved.function, argTypes[i], parm.Type.Value)
+		}
+	}
+	return callee.ReturnType.Value
+}
@@ -0,0 +1,65 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+
+	"jack/JackC/ast"
+)
+
+func checkSource(t *testing.T, strictness Strictness, source string) error {
+	t.Helper()
+	c := NewCompiler()
+	c.SetTypeCheck(strictness)
+	class, err := c.Parse(strings.NewReader(source), "Foo.jack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c.Check([]*ast.Class{class})
+}
+
+func TestStrictDispose(t *testing.T) {
+	// a typical class: it frees itself through Memory.deAlloc, which takes
+	// an Array, and compares and returns chars with character codes
+	err := checkSource(t, Strict, `class Foo {
+    field Array a;
+    field char key;
+
+    constructor Foo new() {
+        let a = Array.new(3);
+        let key = 0;
+        return this;
+    }
+
+    method boolean isEnter() {
+        return key = 128;
+    }
+
+    method char quote() {
+        return 34;
+    }
+
+    method void dispose() {
+        do a.dispose();
+        do Memory.deAlloc(this);
+        return;
+    }
+}
+`)
+	if err != nil {
+		t.Errorf("strict check: %v, want no errors", err)
+	}
+}
+
+func TestStrictCharArithmetic(t *testing.T) {
+	// only a constant stands for a char, not arithmetic on character codes
+	err := checkSource(t, Strict, `class Foo {
+    function char next(char c) {
+        return c + 1;
+    }
+}
+`)
+	if err == nil {
+		t.Errorf("strict check of c + 1 returned as char passed, want an error")
+	}
+}
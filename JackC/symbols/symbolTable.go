@@ -37,9 +37,9 @@ const (
 )
 
 var simpleTypeStrings []string = []string{
-	"Int",
-	"Char",
-	"Boolean",
+	"int",
+	"char",
+	"boolean",
 }
 
 func (st SymbolType) String() string {
@@ -49,6 +49,11 @@ func (st SymbolType) String() string {
 	return simpleTypeStrings[st.simpleType]
 }
 
+// IsClass reports whether the type is a class rather than int, char or boolean.
+func (st SymbolType) IsClass() bool {
+	return st.simpleType == CLASS
+}
+
 type Symbol struct {
 	kindOf SymbolKind
 	typeOf SymbolType
@@ -62,9 +67,6 @@ type SymbolTable struct {
 	Map  map[string]Symbol
 }
 
-var classSymbolTable SymbolTable
-var subroutingSymbolTable SymbolTable
-
 func NewSymbolTable(name string) SymbolTable {
 	return SymbolTable{Name: name, Map: make(map[string]Symbol)}
 }
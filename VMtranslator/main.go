@@ -4,11 +4,17 @@ import (
 	"flag"
 	"fmt"
 	"jack"
+	"jack/VMtranslator/oslib"
 	"jack/VMtranslator/parser"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
 func printErrorAndExit(err interface{}) {
+	if diagnostics, ok := err.(*jack.Diagnostics); ok {
+		err = diagnostics.Report()
+	}
 	fmt.Fprintln(os.Stderr, err)
 	os.Exit(1)
 }
@@ -17,22 +23,113 @@ func printErrorAndExit(err interface{}) {
 
 func main() {
 	var noBoot bool
+	var toGo bool
+	var packageName string
+	var osLibDir string
+	var noOsLib bool
+	var analyze bool
+	var output string
+	var outDir string
+	var writeMap bool
+	config := parser.DefaultConfig()
 	flag.BoolVar(&noBoot, "noboot", false, "do not add boot code")
+	flag.IntVar(&config.StackPointer, "sp", config.StackPointer, "initial stack pointer")
+	flag.StringVar(&config.Entry, "entry", config.Entry, "function called by the boot code, empty to start at the first command")
+	flag.IntVar(&config.Local, "lcl", config.Local, "initial value of LCL, -1 to leave unset")
+	flag.IntVar(&config.Argument, "arg", config.Argument, "initial value of ARG, -1 to leave unset")
+	flag.IntVar(&config.This, "this", config.This, "initial value of THIS, -1 to leave unset")
+	flag.IntVar(&config.That, "that", config.That, "initial value of THAT, -1 to leave unset")
+	flag.IntVar(&config.StackLimit, "stacklimit", config.StackLimit, "check on function entry that SP stays at or below this address, 0 for no checks")
+	flag.BoolVar(&config.TailCalls, "tco", false, "optimise calls whose result is returned at once into frame-reusing jumps")
+	flag.BoolVar(&config.InlineMath, "inlinemath", false, "replace calls to Math.multiply and Math.divide with inline assembly")
+	flag.StringVar(&osLibDir, "oslib", "", "directory of OS .vm files to link instead of the built-in library")
+	flag.BoolVar(&noOsLib, "nooslib", false, "do not link any OS library")
+	flag.BoolVar(&analyze, "analyze", false, "report stack heights and depths instead of translating")
+	flag.BoolVar(&toGo, "go", false, "generate a Go package instead of Hack assembly")
+	flag.StringVar(&packageName, "pkg", "", "package name of generated Go code (default derived from input name)")
+	flag.StringVar(&output, "o", "", "output file, - for standard output (default named after the first input)")
+	flag.StringVar(&outDir, "out-dir", "", "directory to write the output file to")
+	flag.BoolVar(&writeMap, "map", false, "write a source map <asm file>.map from assembly lines to VM commands")
+	diagnosticFlags := jack.AddDiagnosticFlags(flag.CommandLine)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: VMtranslator [options] <vm file or directory | -> ...\n")
+		flag.PrintDefaults()
+	}
 	flag.Parse()
-	arg := flag.Arg(0)
-	if len(flag.Args()) != 1 {
-		printErrorAndExit("Usage: VMtranslator <vm file or directory>")
+	config.Boot = !noBoot
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(1)
 	}
-	basename, filenames, err := jack.Resolve(arg, ".vm")
+	if output != "" && outDir != "" {
+		printErrorAndExit("-o and -out-dir cannot be used together")
+	}
+	err := diagnosticFlags.Setup()
 	if err != nil {
 		printErrorAndExit(err)
 	}
-	asmFilename := basename + ".asm"
-	asmFile, err := os.Create(asmFilename)
+	sources, err := jack.ResolveAll(flag.Args(), ".vm")
+	if err != nil {
+		printErrorAndExit(err)
+	}
+	var filenames []string
+	for _, src := range sources {
+		filenames = append(filenames, src.Filename)
+	}
+	if noOsLib {
+		parser.SetLibrary("", nil)
+	} else if osLibDir != "" {
+		parser.SetLibrary(osLibDir, os.DirFS(osLibDir))
+	} else {
+		parser.SetLibrary("oslib", oslib.FS)
+	}
+	if analyze {
+		err = parser.AnalyzeFiles(filenames, config, os.Stdout)
+		if err != nil {
+			printErrorAndExit(err)
+		}
+		parser.Diagnostics().Report()
+		return
+	}
+	if toGo {
+		if output == "" {
+			output = jack.ProgramFilename(flag.Arg(0), outDir, ".go")
+		}
+		if packageName == "" {
+			packageName = parser.GoPackageName(strings.TrimSuffix(filepath.Base(output), ".go"))
+		}
+		goFile, err := jack.Create(output)
+		if err != nil {
+			printErrorAndExit(err)
+		}
+		defer goFile.Close()
+		parser.SetWriter(goFile)
+		err = parser.TranslateToGo(filenames, packageName, config)
+		if err != nil {
+			printErrorAndExit(err)
+		}
+		parser.Diagnostics().Report()
+		return
+	}
+	if output == "" {
+		output = jack.ProgramFilename(flag.Arg(0), outDir, ".asm")
+	}
+	asmFile, err := jack.Create(output)
 	if err != nil {
 		printErrorAndExit(err)
 	}
 	defer asmFile.Close()
 	parser.SetWriter(asmFile)
-	parser.ParseFiles(filenames, !noBoot)
+	sourceMap, err := parser.ParseFiles(filenames, config)
+	if err != nil {
+		printErrorAndExit(err)
+	}
+	parser.Diagnostics().Report()
+	if writeMap && output != jack.Stdio {
+		sourceMap.Generated = output
+		err = sourceMap.WriteFile()
+		if err != nil {
+			printErrorAndExit(err)
+		}
+	}
 }
@@ -0,0 +1,8 @@
+// Package oslib embeds the standard Jack OS library as VM code. The .vm
+// files are compiled by JackC from the sources in the jack directory.
+package oslib
+
+import "embed"
+
+//go:embed *.vm
+var FS embed.FS
@@ -6,7 +6,16 @@ import (
 	"strconv"
 )
 
-var labelsn = 1000
+// labelCounts numbers the labels made by newLabel separately in every
+// function, so the code of a function does not depend on what precedes it.
+var labelCounts map[string]int
+
+// The shared routines written by writeRoutines.
+const (
+	callRoutine   = "$call"
+	returnRoutine = "$return"
+	routinesEnd   = "$routines.end"
+)
 
 func writeCode(cmd command) {
 	switch cmd.ctype {
@@ -22,6 +31,14 @@ func writeCode(cmd command) {
 		writeIf(cmd)
 	case C_CALL:
 		writeCall(cmd)
+	case C_TAILCALL:
+		writeTailCall(cmd)
+	case C_MULTIPLY:
+		writeMultiply(cmd)
+	case C_DIVIDE:
+		writeDivide(cmd)
+	case C_INLINED:
+		// folded into the next command
 	case C_FUNCTION:
 		writeFunction(cmd)
 	case C_RETURN:
@@ -30,16 +47,20 @@ func writeCode(cmd command) {
 }
 
 func writeBoot() {
-	write("@256")
-	write("D=A")
-	write("@SP")
-	write("M=D")
-	callSysInit := command{
-		function: "Boot",
-		arg1:     "Sys.init",
-		arg2:     "0",
+	for _, reg := range config.presetRegisters() {
+		write("@%d", reg.value)
+		write("D=A")
+		write("@%s", reg.name)
+		write("M=D")
+	}
+	if config.Entry != "" {
+		callEntry := command{
+			function: "Boot",
+			arg1:     config.Entry,
+			arg2:     "0",
+		}
+		writeCall(callEntry)
 	}
-	writeCall(callSysInit)
 }
 
 func pushAddressAt(address string) {
@@ -48,31 +69,114 @@ func pushAddressAt(address string) {
 	pushDRegister()
 }
 
+// writeCall calls cmd.arg1 through the routine of writeCallRoutine, passing
+// the number of words below the new frame in R13, the callee in R14 and the
+// return address in D, which keeps the code of every call short.
 func writeCall(cmd command) {
-	returnAddress := fmt.Sprintf("Ret%s%s", cmd.function, newLabel())
+	returnAddress := newLabel(cmd.function)
+	n, _ := strconv.Atoi(cmd.arg2)
+	write("@%d", n+5)
+	write("D=A")
+	write("@R13")
+	write("M=D")
+	write("@%s", cmd.arg1)
+	write("D=A")
+	write("@R14")
+	write("M=D")
 	write("@%s", returnAddress)
 	write("D=A")
+	write("@%s", callRoutine)
+	write("0;JMP")
+	write("(%s)", returnAddress)
+}
+
+func writeCallRoutine() {
+	write("// push return address and frame, ARG = SP - R13, LCL = SP, goto R14")
+	write("(%s)", callRoutine)
 	pushDRegister()
 	pushAddressAt("LCL")
 	pushAddressAt("ARG")
 	pushAddressAt("THIS")
 	pushAddressAt("THAT")
-	// SP = SP - n - 5
+	write("@SP")
+	write("D=M")
+	write("@R13")
+	write("D=D-M")
+	write("@ARG")
+	write("M=D")
+	write("@SP")
+	write("D=M")
+	write("@LCL")
+	write("M=D")
+	write("@R14")
+	write("A=M")
+	write("0;JMP")
+}
+
+// writeTailCall calls cmd.arg1 in place of the current function: the saved
+// frame of the current function is pushed above the new arguments, both are
+// copied down over the current arguments, and the callee starts with the
+// stack above them, so it returns straight to our caller.
+func writeTailCall(cmd command) {
 	n, _ := strconv.Atoi(cmd.arg2)
-	offset := n + 5
+	words := n + 5
+	write("// push the saved frame above the arguments")
+	for offset := 5; offset > 0; offset-- {
+		write("@LCL")
+		write("D=M")
+		write("@%d", offset)
+		write("A=D-A")
+		write("D=M")
+		pushDRegister()
+	}
+	write("// copy arguments and frame down to ARG: R13 = source, R14 = destination, R15 = count")
 	write("@SP")
 	write("D=M")
-	write("@%d", offset)
+	write("@%d", words)
 	write("D=D-A")
+	write("@R13")
+	write("M=D")
 	write("@ARG")
+	write("D=M")
+	write("@R14")
 	write("M=D")
-	write("@SP")
+	write("@%d", words)
+	write("D=A")
+	write("@R15")
+	write("M=D")
+	loopLabel := newLabel(cmd.function)
+	endLabel := newLabel(cmd.function)
+	write("(%s)", loopLabel)
+	write("@R15")
 	write("D=M")
+	write("@%s", endLabel)
+	write("D;JEQ")
+	write("@R13")
+	write("A=M")
+	write("D=M")
+	write("@R14")
+	write("A=M")
+	write("M=D")
+	write("@R13")
+	write("M=M+1")
+	write("@R14")
+	write("M=M+1")
+	write("@R15")
+	write("M=M-1")
+	write("@%s", loopLabel)
+	write("0;JMP")
+	write("(%s)", endLabel)
+	write("// LCL = SP = ARG + n + 5")
+	write("@ARG")
+	write("D=M")
+	write("@%d", words)
+	write("D=D+A")
 	write("@LCL")
 	write("M=D")
+	write("@SP")
+	write("M=D")
 	write("@%s", cmd.arg1)
-	write("D;JMP")
-	write("(%s)", returnAddress)
+	write("0;JMP")
 }
 
 func writeFunction(cmd command) {
@@ -82,9 +186,67 @@ func writeFunction(cmd command) {
 	for i := 0; i < n; i++ {
 		pushDRegister()
 	}
+	if config.StackLimit > 0 {
+		writeStackCheck()
+	}
+}
+
+// writeRoutines writes the shared routines used by the commands. They come
+// right after the bootstrap code so that their addresses stay small however
+// large the program grows, with a jump over them for when there is no
+// bootstrap code.
+func writeRoutines() {
+	usesCall := config.Boot && config.Entry != ""
+	usesReturn := false
+	for _, cmd := range commands {
+		switch cmd.ctype {
+		case C_CALL:
+			usesCall = true
+		case C_RETURN:
+			usesReturn = true
+		}
+	}
+	usesMath := usesMathRoutines()
+	if !usesCall && !usesReturn && !usesMath {
+		return
+	}
+	write("@%s", routinesEnd)
+	write("0;JMP")
+	if usesCall {
+		writeCallRoutine()
+	}
+	if usesReturn {
+		writeReturnRoutine()
+	}
+	if usesMath {
+		writeMathRoutines()
+	}
+	write("(%s)", routinesEnd)
+}
+
+func writeStackCheck() {
+	write("@SP")
+	write("D=M")
+	write("@%d", config.StackLimit)
+	write("D=D-A")
+	write("@STACK_OVERFLOW")
+	write("D;JGT")
+}
+
+func writeStackOverflowHandler() {
+	write("// stack overflow: SP went past %d, stop here", config.StackLimit)
+	write("(STACK_OVERFLOW)")
+	write("@STACK_OVERFLOW")
+	write("0;JMP")
 }
 
 func writeReturn(cmd command) {
+	write("@%s", returnRoutine)
+	write("0;JMP")
+}
+
+func writeReturnRoutine() {
+	write("(%s)", returnRoutine)
 	write("//FRAME = LCL")
 	write("@LCL")
 	write("D=M")
@@ -127,19 +289,25 @@ func decrementR13AndRestoreAddressIn(regName string) {
 	write("M=D")
 }
 
+// labelKey scopes a VM label to the function it appears in, so that labels
+// such as IF0 in different functions do not collide.
+func labelKey(function, label string) string {
+	return function + "$" + label
+}
+
 func writeGoto(cmd command) {
-	write("@%s", cmd.arg1)
+	write("@%s", labelKey(cmd.function, cmd.arg1))
 	write("D;JMP")
 }
 
 func writeIf(cmd command) {
 	popIntoDRegister()
-	write("@%s", cmd.arg1)
+	write("@%s", labelKey(cmd.function, cmd.arg1))
 	write("D;JNE")
 }
 
 func writeLabel(cmd command) {
-	write("(%s)", cmd.arg1)
+	write("(%s)", labelKey(cmd.function, cmd.arg1))
 }
 
 func writePushOrPop(cmd command) {
@@ -239,8 +407,8 @@ func writeArithmetic(cmd command) {
 	case "or":
 		write("D=D|M")
 	case "eq", "gt", "lt":
-		falseLabel := newLabel()
-		trueLabel := newLabel()
+		falseLabel := newLabel(cmd.function)
+		trueLabel := newLabel(cmd.function)
 		write("D=D-M")
 		write("@%s", trueLabel)
 		switch cmd.command {
@@ -261,14 +429,20 @@ func writeArithmetic(cmd command) {
 	pushDRegister()
 }
 
-func newLabel() string {
-	labelsn++
-	return fmt.Sprintf("L%d", labelsn)
+// newLabel returns a fresh label in function. VM labels cannot start with a
+// digit, so these never clash with the labels of labelKey.
+func newLabel(function string) string {
+	labelCounts[function]++
+	return fmt.Sprintf("%s$%d", function, labelCounts[function])
 }
 
+// asmLine is the number of lines written so far.
+var asmLine int
+
 func write(format string, a ...interface{}) {
 	outString := fmt.Sprintf(format, a...) + "\n"
 	io.WriteString(asmFile, outString)
+	asmLine++
 }
 
 func popIntoDRegister() {
@@ -279,9 +453,8 @@ func popIntoDRegister() {
 }
 
 func pushDRegister() {
-	write("@SP")   // A=2, so M will be RAM[2]
-	write("A=M")   // A is RAM[2], which is address where value will be pushed
-	write("M=D")   // put value of D into RAM[A]
-	write("@SP")   // A=2 again, so M is RAM[2]
-	write("M=M+1") // increment value in RAM[2] and overwrite it
+	write("@SP")    // A=0, so M will be RAM[0]
+	write("AM=M+1") // increment SP, A is the new SP
+	write("A=A-1")  // A is the address where the value will be pushed
+	write("M=D")    // put value of D into RAM[A]
 }
@@ -0,0 +1,470 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// goProgram holds what the Go backend needs to know about the whole program
+// before it can emit a single case of the Step switch.
+type goProgram struct {
+	commands  []command
+	functions map[string]int // function name -> index of its 'function' command
+	labels    map[string]int // function$label -> index of the 'label' command
+	statics   map[string]int // Module.index -> RAM address
+}
+
+func TranslateToGo(filenames []string, packageName string, cfg Config) error {
+	config = cfg
+	program, err := loadProgram(filenames)
+	if err != nil {
+		return err
+	}
+	program.writeHeader(packageName)
+	program.writeStep()
+	return nil
+}
+
+// loadProgram parses and links the VM files and resolves the whole program,
+// with the boot call as its first command.
+func loadProgram(filenames []string) (*goProgram, error) {
+	err := parseFiles(filenames)
+	if err != nil {
+		return nil, err
+	}
+	optimize()
+	program := &goProgram{}
+	if config.Boot && config.Entry != "" {
+		program.commands = append(program.commands, bootCall())
+	}
+	program.commands = append(program.commands, commands...)
+	err = program.resolve()
+	if err != nil {
+		return nil, err
+	}
+	return program, nil
+}
+
+func bootCall() command {
+	return command{
+		ctype:    C_CALL,
+		function: "Boot",
+		command:  "call",
+		arg1:     config.Entry,
+		arg2:     "0",
+		origLine: "call " + config.Entry + " 0",
+	}
+}
+
+func GoPackageName(basename string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(basename) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	name := b.String()
+	if name == "" || unicode.IsDigit(rune(name[0])) {
+		name = "vm" + name
+	}
+	return name
+}
+
+func (p *goProgram) resolve() error {
+	p.functions = make(map[string]int)
+	p.labels = make(map[string]int)
+	p.statics = make(map[string]int)
+	nextStatic := 16
+	for pc, cmd := range p.commands {
+		switch cmd.ctype {
+		case C_FUNCTION:
+			p.functions[cmd.arg1] = pc
+		case C_LABEL:
+			p.labels[labelKey(cmd.function, cmd.arg1)] = pc
+		case C_PUSH, C_POP:
+			if cmd.arg1 == "constant" {
+				if n, _ := strconv.Atoi(cmd.arg2); n > 32767 {
+					errorAt(cmd, cmd.arg2, "constant %s out of range", cmd.arg2)
+				}
+			}
+			if cmd.arg1 != "static" {
+				continue
+			}
+			name := cmd.module + "." + cmd.arg2
+			if _, ok := p.statics[name]; ok {
+				continue
+			}
+			if nextStatic > 255 {
+				errorAt(cmd, "", "too many static variables")
+				return failed()
+			}
+			p.statics[name] = nextStatic
+			nextStatic++
+		}
+	}
+	for _, cmd := range p.commands {
+		if cmd.ctype == C_GOTO || cmd.ctype == C_IF {
+			if _, ok := p.labels[labelKey(cmd.function, cmd.arg1)]; !ok {
+				errorAt(cmd, cmd.arg1, "undefined label %s", cmd.arg1)
+			}
+		}
+	}
+	return failed()
+}
+
+func (p *goProgram) writeHeader(packageName string) {
+	write("// Code generated by VMtranslator; DO NOT EDIT.")
+	write("")
+	write("package %s", packageName)
+	write("")
+	write("// RAM addresses of the virtual registers.")
+	write("const (")
+	write("\tSP   = 0")
+	write("\tLCL  = 1")
+	write("\tARG  = 2")
+	write("\tTHIS = 3")
+	write("\tTHAT = 4")
+	write(")")
+	write("")
+	write("// KBD is the RAM address of the keyboard, which holds the code of the key")
+	write("// pressed, 0 for none.")
+	write("const KBD = 24576")
+	write("")
+	write("const numCommands = %d", len(p.commands))
+	write("")
+	write("// Machine runs the translated VM program one VM command per Step, using")
+	write("// the same RAM layout as the Hack platform.")
+	write("type Machine struct {")
+	write("\tRAM           [32768]int16")
+	write("\tPC            int")
+	write("\tStackOverflow bool // a function was entered with SP past the stack limit")
+	write("\thalted        bool")
+	write("")
+	write("\t// loop detection, see checkLoop")
+	write("\twaiting       bool")
+	write("\tsnapshot      [32768]int16")
+	write("\tsnapshotPC    int")
+	write("\tdifferences   int")
+	write("\tsinceSnapshot int")
+	write("}")
+	write("")
+	write("// snapshotInterval is the number of steps after which checkLoop takes a new")
+	write("// snapshot of the machine.")
+	write("const snapshotInterval = 1 << 16")
+	write("")
+	write("func NewMachine() *Machine {")
+	write("\tm := &Machine{}")
+	write("\tm.Reset()")
+	write("\treturn m")
+	write("}")
+	write("")
+	write("func (m *Machine) Reset() {")
+	write("\tm.RAM = [32768]int16{}")
+	write("\tm.PC = 0")
+	write("\tm.StackOverflow = false")
+	write("\tm.halted = false")
+	write("\tm.waiting = false")
+	if config.Boot {
+		for _, reg := range config.presetRegisters() {
+			write("\tm.RAM[%s] = %d", reg.name, reg.value)
+		}
+	}
+	write("\tm.takeSnapshot()")
+	write("}")
+	write("")
+	write("// Halted reports whether the machine has stopped: the program ran off its")
+	write("// end or failed, or it waits in a loop that it cannot leave without input,")
+	write("// such as the one of Sys.halt or one polling the keyboard. A wait is only")
+	write("// a pause: the next Run or Step carries on, so a program waiting for a key")
+	write("// goes on once RAM[KBD] is set.")
+	write("func (m *Machine) Halted() bool {")
+	write("\treturn m.halted || m.waiting || m.PC < 0 || m.PC >= numCommands")
+	write("}")
+	write("")
+	write("// Run steps the machine until it halts or maxSteps commands have run;")
+	write("// maxSteps <= 0 means no limit. It returns the number of steps taken.")
+	write("func (m *Machine) Run(maxSteps int) int {")
+	write("\tm.resume()")
+	write("\tsteps := 0")
+	write("\tfor !m.Halted() && (maxSteps <= 0 || steps < maxSteps) {")
+	write("\t\tm.Step()")
+	write("\t\tsteps++")
+	write("\t}")
+	write("\treturn steps")
+	write("}")
+	write("")
+	write("func address(value int16) int {")
+	write("\treturn int(uint16(value)) & 0x7fff")
+	write("}")
+	write("")
+	write("func truth(b bool) int16 {")
+	write("\tif b {")
+	write("\t\treturn -1")
+	write("\t}")
+	write("\treturn 0")
+	write("}")
+	write("")
+	write("// write stores value at address, counting the words that differ from the")
+	write("// snapshot of checkLoop.")
+	write("func (m *Machine) write(address int, value int16) {")
+	write("\tif m.RAM[address] == m.snapshot[address] && value != m.snapshot[address] {")
+	write("\t\tm.differences++")
+	write("\t} else if m.RAM[address] != m.snapshot[address] && value == m.snapshot[address] {")
+	write("\t\tm.differences--")
+	write("\t}")
+	write("\tm.RAM[address] = value")
+	write("}")
+	write("")
+	write("// checkLoop notices when the machine gets back into the state of its last")
+	write("// snapshot. Nothing but the keyboard can change the course of the program,")
+	write("// so without input it will then repeat itself for ever. The keyboard is")
+	write("// compared as well, as it is set from outside without write.")
+	write("func (m *Machine) checkLoop() {")
+	write("\tif m.PC == m.snapshotPC && m.differences == 0 && m.RAM[KBD] == m.snapshot[KBD] {")
+	write("\t\tm.waiting = true")
+	write("\t\treturn")
+	write("\t}")
+	write("\tm.sinceSnapshot++")
+	write("\tif m.sinceSnapshot >= snapshotInterval {")
+	write("\t\tm.takeSnapshot()")
+	write("\t}")
+	write("}")
+	write("")
+	write("// resume ends a wait. The new snapshot takes in whatever was written to")
+	write("// RAM from outside since the last one.")
+	write("func (m *Machine) resume() {")
+	write("\tm.waiting = false")
+	write("\tm.takeSnapshot()")
+	write("}")
+	write("")
+	write("func (m *Machine) takeSnapshot() {")
+	write("\tm.snapshot = m.RAM")
+	write("\tm.snapshotPC = m.PC")
+	write("\tm.differences = 0")
+	write("\tm.sinceSnapshot = 0")
+	write("}")
+	write("")
+	write("func (m *Machine) push(value int16) {")
+	write("\tm.write(address(m.RAM[SP]), value)")
+	write("\tm.write(SP, m.RAM[SP]+1)")
+	write("}")
+	write("")
+	write("func (m *Machine) pop() int16 {")
+	write("\tm.write(SP, m.RAM[SP]-1)")
+	write("\treturn m.RAM[address(m.RAM[SP])]")
+	write("}")
+	write("")
+	write("func (m *Machine) segment(base int, index int16) int {")
+	write("\treturn address(m.RAM[base] + index)")
+	write("}")
+	write("")
+	write("func (m *Machine) call(target int, nArgs int16) {")
+	write("\tm.push(int16(m.PC + 1))")
+	write("\tm.push(m.RAM[LCL])")
+	write("\tm.push(m.RAM[ARG])")
+	write("\tm.push(m.RAM[THIS])")
+	write("\tm.push(m.RAM[THAT])")
+	write("\tm.write(ARG, m.RAM[SP]-nArgs-5)")
+	write("\tm.write(LCL, m.RAM[SP])")
+	write("\tm.PC = target")
+	write("}")
+	write("")
+	write("func (m *Machine) ret() {")
+	write("\tframe := m.RAM[LCL]")
+	write("\treturnAddress := m.RAM[address(frame-5)]")
+	write("\tm.write(address(m.RAM[ARG]), m.pop())")
+	write("\tm.write(SP, m.RAM[ARG]+1)")
+	write("\tm.write(THAT, m.RAM[address(frame-1)])")
+	write("\tm.write(THIS, m.RAM[address(frame-2)])")
+	write("\tm.write(ARG, m.RAM[address(frame-3)])")
+	write("\tm.write(LCL, m.RAM[address(frame-4)])")
+	write("\tm.PC = int(uint16(returnAddress))")
+	write("}")
+	write("")
+	if config.TailCalls {
+		write("// tailCall calls target in place of the current function, reusing its frame.")
+		write("func (m *Machine) tailCall(target int, nArgs int16) {")
+		write("\tframe := m.RAM[LCL]")
+		write("\tfor offset := int16(5); offset > 0; offset-- {")
+		write("\t\tm.push(m.RAM[address(frame-offset)])")
+		write("\t}")
+		write("\tsource := m.RAM[SP] - nArgs - 5")
+		write("\tdestination := m.RAM[ARG]")
+		write("\tfor i := int16(0); i < nArgs+5; i++ {")
+		write("\t\tm.write(address(destination+i), m.RAM[address(source+i)])")
+		write("\t}")
+		write("\tm.write(LCL, destination+nArgs+5)")
+		write("\tm.write(SP, m.RAM[LCL])")
+		write("\tm.PC = target")
+		write("}")
+		write("")
+	}
+}
+
+func (p *goProgram) writeStep() {
+	write("// Step executes the VM command at PC.")
+	write("func (m *Machine) Step() {")
+	write("\tif m.waiting {")
+	write("\t\tm.resume()")
+	write("\t}")
+	write("\tif m.Halted() {")
+	write("\t\treturn")
+	write("\t}")
+	write("\tm.step()")
+	write("\tm.checkLoop()")
+	write("}")
+	write("")
+	write("func (m *Machine) step() {")
+	write("\tswitch m.PC {")
+	for pc, cmd := range p.commands {
+		write("\tcase %d: // %s", pc, strings.TrimSpace(cmd.origLine))
+		p.writeGoCode(pc, cmd)
+	}
+	write("\t}")
+	write("\tm.PC++")
+	write("}")
+}
+
+func (p *goProgram) writeGoCode(pc int, cmd command) {
+	switch cmd.ctype {
+	case C_ARITHMETIC:
+		writeGoArithmetic(cmd)
+	case C_PUSH:
+		write("\t\tm.push(%s)", p.goLocation(cmd))
+	case C_POP:
+		write("\t\tvalue := m.pop()")
+		write("\t\tm.write(%s, value)", p.goAddress(cmd))
+	case C_LABEL:
+		// labels only mark a position
+	case C_GOTO:
+		target := p.labels[labelKey(cmd.function, cmd.arg1)]
+		write("\t\tm.PC = %d", target)
+		if p.isSelfLoop(target, pc) {
+			write("\t\tm.halted = true")
+		}
+		write("\t\treturn")
+	case C_IF:
+		write("\t\tif m.pop() != 0 {")
+		write("\t\t\tm.PC = %d", p.labels[labelKey(cmd.function, cmd.arg1)])
+		write("\t\t\treturn")
+		write("\t\t}")
+	case C_FUNCTION:
+		n, _ := strconv.Atoi(cmd.arg2)
+		if n > 0 {
+			write("\t\tfor i := 0; i < %d; i++ {", n)
+			write("\t\t\tm.push(0)")
+			write("\t\t}")
+		}
+		if config.StackLimit > 0 {
+			write("\t\tif m.RAM[SP] > %d {", config.StackLimit)
+			write("\t\t\tm.StackOverflow = true")
+			write("\t\t\tm.halted = true")
+			write("\t\t\treturn")
+			write("\t\t}")
+		}
+	case C_CALL:
+		write("\t\tm.call(%d, %s)", p.functions[cmd.arg1], cmd.arg2)
+		write("\t\treturn")
+	case C_TAILCALL:
+		write("\t\tm.tailCall(%d, %s)", p.functions[cmd.arg1], cmd.arg2)
+		write("\t\treturn")
+	case C_MULTIPLY:
+		if cmd.shift >= 0 {
+			write("\t\tm.push(m.pop() << %d)", cmd.shift)
+		} else {
+			write("\t\ty := m.pop()")
+			write("\t\tm.push(m.pop() * y)")
+		}
+	case C_DIVIDE:
+		if cmd.shift >= 0 {
+			write("\t\tm.push(m.pop() / %d)", 1<<cmd.shift)
+		} else {
+			write("\t\ty := m.pop()")
+			write("\t\tif y == 0 {")
+			write("\t\t\tm.halted = true")
+			write("\t\t\treturn")
+			write("\t\t}")
+			write("\t\tm.push(m.pop() / y)")
+		}
+	case C_INLINED:
+		// folded into the next command
+	case C_RETURN:
+		write("\t\tm.ret()")
+		write("\t\treturn")
+	}
+}
+
+// isSelfLoop reports whether the goto at pc jumps back to itself with
+// nothing but labels in between. Such a loop halts the machine at once,
+// without waiting for checkLoop to notice it.
+func (p *goProgram) isSelfLoop(target, pc int) bool {
+	if target > pc {
+		return false
+	}
+	for i := target; i < pc; i++ {
+		if p.commands[i].ctype != C_LABEL {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *goProgram) goLocation(cmd command) string {
+	if cmd.arg1 == "constant" {
+		return cmd.arg2
+	}
+	return "m.RAM[" + p.goAddress(cmd) + "]"
+}
+
+// goAddress returns the RAM address of the segment word of cmd.
+func (p *goProgram) goAddress(cmd command) string {
+	switch cmd.arg1 {
+	case "local":
+		return fmt.Sprintf("m.segment(LCL, %s)", cmd.arg2)
+	case "argument":
+		return fmt.Sprintf("m.segment(ARG, %s)", cmd.arg2)
+	case "this":
+		return fmt.Sprintf("m.segment(THIS, %s)", cmd.arg2)
+	case "that":
+		return fmt.Sprintf("m.segment(THAT, %s)", cmd.arg2)
+	case "pointer", "temp":
+		offset, _ := strconv.Atoi(cmd.arg2)
+		if cmd.arg1 == "temp" {
+			offset += 5
+		} else {
+			offset += 3
+		}
+		return strconv.Itoa(offset)
+	case "static":
+		return strconv.Itoa(p.statics[cmd.module+"."+cmd.arg2])
+	}
+	return ""
+}
+
+func writeGoArithmetic(cmd command) {
+	switch cmd.command {
+	case "neg":
+		write("\t\tm.push(-m.pop())")
+	case "not":
+		write("\t\tm.push(^m.pop())")
+	case "add":
+		write("\t\tm.push(m.pop() + m.pop())")
+	case "and":
+		write("\t\tm.push(m.pop() & m.pop())")
+	case "or":
+		write("\t\tm.push(m.pop() | m.pop())")
+	case "eq":
+		write("\t\tm.push(truth(m.pop() == m.pop()))")
+	case "sub":
+		write("\t\ty := m.pop()")
+		write("\t\tm.push(m.pop() - y)")
+	case "gt":
+		write("\t\ty := m.pop()")
+		write("\t\tm.push(truth(m.pop() > y))")
+	case "lt":
+		write("\t\ty := m.pop()")
+		write("\t\tm.push(truth(m.pop() < y))")
+	}
+}
@@ -0,0 +1,136 @@
+package parser
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"jack/VMtranslator/oslib"
+)
+
+// writeSources writes the VM code of files to a directory of their own and
+// returns their names in a fixed order.
+func writeSources(t *testing.T, files map[string]string) []string {
+	t.Helper()
+	dir := t.TempDir()
+	var filenames []string
+	for name, code := range files {
+		filename := filepath.Join(dir, name)
+		err := os.WriteFile(filename, []byte(code), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+	return filenames
+}
+
+// runGo translates files into a Go package main, adds main, which uses the
+// generated Machine, and builds and runs it, returning what it prints.
+func runGo(t *testing.T, files map[string]string, cfg Config, main string) string {
+	t.Helper()
+	goCommand, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("no go command to run the generated code")
+	}
+	var code bytes.Buffer
+	SetWriter(&code)
+	err = TranslateToGo(writeSources(t, files), "main", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"machine.go": code.String(),
+		"main.go":    "package main\n\nimport \"fmt\"\n\n" + main,
+	} {
+		err = os.WriteFile(filepath.Join(dir, name), []byte(data), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	build := exec.Command(goCommand, "build", "-o", "machine", "machine.go", "main.go")
+	build.Dir = dir
+	output, err := build.CombinedOutput()
+	if err != nil {
+		t.Fatalf("go build: %v\n%s", err, output)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	output, err = exec.CommandContext(ctx, filepath.Join(dir, "machine")).CombinedOutput()
+	if ctx.Err() != nil {
+		t.Fatalf("generated program did not finish: %v", ctx.Err())
+	}
+	if err != nil {
+		t.Fatalf("running generated program: %v\n%s", err, output)
+	}
+	return strings.TrimSpace(string(output))
+}
+
+func TestGoMachineHaltsInSysHalt(t *testing.T) {
+	SetLibrary("oslib", oslib.FS)
+	defer SetLibrary("", nil)
+	files := map[string]string{
+		"Main.vm": `function Main.main 0
+push constant 8000
+push constant 7
+push constant 6
+call Math.multiply 2
+call Memory.poke 2
+pop temp 0
+push constant 0
+return
+`,
+	}
+	output := runGo(t, files, DefaultConfig(), `func main() {
+	m := NewMachine()
+	m.Run(0)
+	fmt.Println(m.Halted(), m.RAM[8000])
+}
+`)
+	if output != "true 42" {
+		t.Errorf("got %q, want Run(0) to return with the machine halted and RAM[8000] = 42", output)
+	}
+}
+
+func TestGoMachineWaitsForKey(t *testing.T) {
+	SetLibrary("oslib", oslib.FS)
+	defer SetLibrary("", nil)
+	// Main.main waits for a key and stores its code at 8000
+	files := map[string]string{
+		"Main.vm": `function Main.main 1
+label WAIT
+call Keyboard.keyPressed 0
+pop local 0
+push local 0
+push constant 0
+eq
+if-goto WAIT
+push constant 8000
+push local 0
+call Memory.poke 2
+pop temp 0
+push constant 0
+return
+`,
+	}
+	output := runGo(t, files, DefaultConfig(), `func main() {
+	m := NewMachine()
+	m.Run(1000000)
+	fmt.Println(m.Halted(), m.RAM[8000])
+	m.RAM[KBD] = 65
+	m.Run(0)
+	fmt.Println(m.Halted(), m.RAM[8000])
+}
+`)
+	if want := "true 0\ntrue 65"; output != want {
+		t.Errorf("got %q, want %q: waiting for the key, then going on when it is pressed", output, want)
+	}
+}
@@ -0,0 +1,340 @@
+package parser
+
+import (
+	"fmt"
+	"sort"
+)
+
+// The shared routines take their operands in R13 and R14 and the return
+// address in R15, leave the result in D, and use the words just above the
+// stack pointer as scratch space.
+const (
+	multiplyRoutine = "$inline.multiply"
+	divideRoutine   = "$inline.divide"
+)
+
+func writeMultiply(cmd command) {
+	if cmd.shift >= 0 {
+		write("// multiply by 2^%d by doubling", cmd.shift)
+		write("@SP")
+		write("A=M-1")
+		write("D=M")
+		for i := 0; i < cmd.shift; i++ {
+			write("MD=D+M")
+		}
+		return
+	}
+	popOperands()
+	writeRoutineCall(cmd.function, multiplyRoutine)
+}
+
+func writeDivide(cmd command) {
+	if cmd.shift == 0 {
+		write("// divide by 1")
+		return
+	}
+	if cmd.shift > 0 {
+		popIntoDRegister()
+		write("@R13")
+		write("M=D")
+		writeRoutineCall(cmd.function, divideByPowerOfTwoRoutine(cmd.shift))
+		return
+	}
+	popOperands()
+	writeRoutineCall(cmd.function, divideRoutine)
+}
+
+// popOperands pops the second operand into R14 and the first into R13.
+func popOperands() {
+	popIntoDRegister()
+	write("@R14")
+	write("M=D")
+	popIntoDRegister()
+	write("@R13")
+	write("M=D")
+}
+
+func writeRoutineCall(function, routine string) {
+	returnAddress := newLabel(function)
+	write("@%s", returnAddress)
+	write("D=A")
+	write("@R15")
+	write("M=D")
+	write("@%s", routine)
+	write("0;JMP")
+	write("(%s)", returnAddress)
+	pushDRegister()
+}
+
+func writeRoutineReturn() {
+	write("@R15")
+	write("A=M")
+	write("0;JMP")
+}
+
+// scratch sets A to the address of the i-th scratch word above SP.
+func scratch(i int) {
+	write("@SP")
+	if i == 0 {
+		write("A=M")
+		return
+	}
+	write("A=M+1")
+	for ; i > 1; i-- {
+		write("A=A+1")
+	}
+}
+
+func divideByPowerOfTwoRoutine(shift int) string {
+	return fmt.Sprintf("%s.%d", divideRoutine, shift)
+}
+
+// mathRoutines returns which of the shared routines of writeMultiply and
+// writeDivide the commands use.
+func mathRoutines() (multiply bool, divide bool, shifts []int) {
+	divideShifts := make(map[int]bool)
+	for _, cmd := range commands {
+		switch {
+		case cmd.ctype == C_MULTIPLY && cmd.shift < 0:
+			multiply = true
+		case cmd.ctype == C_DIVIDE && cmd.shift < 0:
+			divide = true
+		case cmd.ctype == C_DIVIDE && cmd.shift > 0:
+			divideShifts[cmd.shift] = true
+		}
+	}
+	for shift := range divideShifts {
+		shifts = append(shifts, shift)
+	}
+	sort.Ints(shifts)
+	return
+}
+
+func usesMathRoutines() bool {
+	multiply, divide, shifts := mathRoutines()
+	return multiply || divide || len(shifts) > 0
+}
+
+// writeMathRoutines writes each shared routine that is used once.
+func writeMathRoutines() {
+	multiply, divide, shifts := mathRoutines()
+	if multiply {
+		writeMultiplyRoutine()
+	}
+	if divide {
+		writeDivideRoutine()
+	}
+	for _, shift := range shifts {
+		writeDivideByPowerOfTwoRoutine(shift)
+	}
+}
+
+// writeMultiplyRoutine computes R13*R14 by shift and add: for every bit of
+// R14, lowest first, add R13 to the sum if the bit is set and double R13.
+// Bits are cleared from R14 as they are used, so the loop stops as soon as
+// no set bits are left. Scratch 0 is the sum, scratch 1 the current bit.
+func writeMultiplyRoutine() {
+	write("// R13 * R14, result in D")
+	write("(%s)", multiplyRoutine)
+	scratch(0)
+	write("M=0")
+	scratch(1)
+	write("M=1")
+	write("(%s.loop)", multiplyRoutine)
+	write("@R14")
+	write("D=M")
+	write("@%s.end", multiplyRoutine)
+	write("D;JEQ")
+	scratch(1)
+	write("D=M")
+	write("@R14")
+	write("D=D&M")
+	write("@%s.next", multiplyRoutine)
+	write("D;JEQ")
+	write("@R14")
+	write("M=M-D")
+	write("@R13")
+	write("D=M")
+	scratch(0)
+	write("M=D+M")
+	write("(%s.next)", multiplyRoutine)
+	write("@R13")
+	write("D=M")
+	write("M=D+M")
+	scratch(1)
+	write("D=M")
+	write("M=D+M")
+	write("@%s.loop", multiplyRoutine)
+	write("0;JMP")
+	write("(%s.end)", multiplyRoutine)
+	scratch(0)
+	write("D=M")
+	writeRoutineReturn()
+}
+
+// writeDivideRoutine computes R13/R14, rounded towards zero, by restoring
+// division of the magnitudes: the dividend is shifted out of R13 from the
+// top bit down into the remainder, and whenever the remainder reaches the
+// divisor it is reduced and a 1 is shifted into the quotient. Divisors
+// above 16383 would overflow the remainder, but then the quotient can only
+// be 0 or 1. Scratch 0 is the remainder, 1 the quotient, 2 the loop count
+// and 3 is -1 if the result is negative. Dividing by zero stops the machine.
+func writeDivideRoutine() {
+	write("// R13 / R14, result in D")
+	write("(%s)", divideRoutine)
+	write("@R14")
+	write("D=M")
+	write("@%s.zero", divideRoutine)
+	write("D;JEQ")
+	scratch(3)
+	write("M=0")
+	write("@R13")
+	write("D=M")
+	write("@%s.positivex", divideRoutine)
+	write("D;JGE")
+	write("@R13")
+	write("M=-M")
+	scratch(3)
+	write("M=!M")
+	write("(%s.positivex)", divideRoutine)
+	write("@R14")
+	write("D=M")
+	write("@%s.positivey", divideRoutine)
+	write("D;JGT")
+	write("@R14")
+	write("M=-M")
+	scratch(3)
+	write("M=!M")
+	write("(%s.positivey)", divideRoutine)
+	write("@16383")
+	write("D=A")
+	write("@R14")
+	write("D=M-D")
+	write("@%s.start", divideRoutine)
+	write("D;JLE")
+	write("// large divisor, the quotient is 1 if R13 >= R14, else 0")
+	write("@R14")
+	write("D=M")
+	write("@R13")
+	write("D=M-D")
+	scratch(1)
+	write("M=0")
+	write("@%s.sign", divideRoutine)
+	write("D;JLT")
+	scratch(1)
+	write("M=1")
+	write("@%s.sign", divideRoutine)
+	write("0;JMP")
+	write("(%s.start)", divideRoutine)
+	scratch(0)
+	write("M=0")
+	scratch(1)
+	write("M=0")
+	write("@15")
+	write("D=A")
+	scratch(2)
+	write("M=D")
+	write("// the magnitude fits in 15 bits, move bit 14 to the top")
+	write("@R13")
+	write("D=M")
+	write("M=D+M")
+	write("(%s.loop)", divideRoutine)
+	scratch(0)
+	write("D=M")
+	write("M=D+M")
+	write("@R13")
+	write("D=M")
+	write("@%s.zerobit", divideRoutine)
+	write("D;JGE")
+	scratch(0)
+	write("M=M+1")
+	write("(%s.zerobit)", divideRoutine)
+	write("@R13")
+	write("D=M")
+	write("M=D+M")
+	scratch(1)
+	write("D=M")
+	write("M=D+M")
+	write("@R14")
+	write("D=M")
+	scratch(0)
+	write("D=M-D")
+	write("@%s.next", divideRoutine)
+	write("D;JLT")
+	scratch(0)
+	write("M=D")
+	scratch(1)
+	write("M=M+1")
+	write("(%s.next)", divideRoutine)
+	scratch(2)
+	write("MD=M-1")
+	write("@%s.loop", divideRoutine)
+	write("D;JGT")
+	write("(%s.sign)", divideRoutine)
+	scratch(1)
+	write("D=M")
+	write("@R13")
+	write("M=D")
+	scratch(3)
+	write("D=M")
+	write("@%s.done", divideRoutine)
+	write("D;JEQ")
+	write("@R13")
+	write("M=-M")
+	write("(%s.done)", divideRoutine)
+	write("@R13")
+	write("D=M")
+	writeRoutineReturn()
+	write("(%s.zero)", divideRoutine)
+	write("@%s.zero", divideRoutine)
+	write("0;JMP")
+}
+
+// writeDivideByPowerOfTwoRoutine computes R13/2^shift, rounded towards
+// zero, by collecting bits shift..15 of the magnitude of R13 into R14.
+// Bit 15 is only set in the magnitude of -32768. The original R13 is kept
+// in scratch 0 for the sign.
+func writeDivideByPowerOfTwoRoutine(shift int) {
+	routine := divideByPowerOfTwoRoutine(shift)
+	write("// R13 / %d, result in D", 1<<shift)
+	write("(%s)", routine)
+	write("@R13")
+	write("D=M")
+	scratch(0)
+	write("M=D")
+	write("@R14")
+	write("M=0")
+	write("@%s.positive", routine)
+	write("D;JGE")
+	write("@R13")
+	write("M=-M")
+	write("(%s.positive)", routine)
+	for bit := shift; bit < 16; bit++ {
+		if bit == 15 {
+			write("@32767")
+			write("D=!A")
+		} else {
+			write("@%d", 1<<bit)
+			write("D=A")
+		}
+		write("@R13")
+		write("D=D&M")
+		write("@%s.skip%d", routine, bit)
+		write("D;JEQ")
+		write("@%d", 1<<(bit-shift))
+		write("D=A")
+		write("@R14")
+		write("M=D+M")
+		write("(%s.skip%d)", routine, bit)
+	}
+	scratch(0)
+	write("D=M")
+	write("@%s.done", routine)
+	write("D;JGE")
+	write("@R14")
+	write("M=-M")
+	write("(%s.done)", routine)
+	write("@R14")
+	write("D=M")
+	writeRoutineReturn()
+}
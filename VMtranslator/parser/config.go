@@ -0,0 +1,51 @@
+package parser
+
+// Config describes the bootstrap code and memory layout assumed by the
+// generated code, and the optimisations applied to it.
+type Config struct {
+	Boot         bool   // emit bootstrap code at all
+	StackPointer int    // initial value of SP
+	Entry        string // function called by the bootstrap code, "" to fall through to the first command
+	Local        int    // initial value of LCL, -1 to leave it unset
+	Argument     int    // initial value of ARG, -1 to leave it unset
+	This         int    // initial value of THIS, -1 to leave it unset
+	That         int    // initial value of THAT, -1 to leave it unset
+	StackLimit   int    // SP may not grow past this address, 0 disables overflow checks
+	TailCalls    bool   // reuse the caller's frame for calls whose result is returned at once
+	InlineMath   bool   // replace calls to Math.multiply and Math.divide with assembly routines
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Boot:         true,
+		StackPointer: 256,
+		Entry:        "Sys.init",
+		Local:        -1,
+		Argument:     -1,
+		This:         -1,
+		That:         -1,
+	}
+}
+
+type register struct {
+	name  string
+	value int
+}
+
+// presetRegisters lists the registers the bootstrap code initialises, in the
+// order they are set.
+func (c Config) presetRegisters() []register {
+	var result []register
+	for _, reg := range []register{
+		{"SP", c.StackPointer},
+		{"LCL", c.Local},
+		{"ARG", c.Argument},
+		{"THIS", c.This},
+		{"THAT", c.That},
+	} {
+		if reg.value >= 0 {
+			result = append(result, reg)
+		}
+	}
+	return result
+}
@@ -0,0 +1,189 @@
+package parser
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"jack"
+	"path"
+	"sort"
+	"strings"
+)
+
+var libraryName string
+var library fs.FS
+
+// SetLibrary sets the OS library that calls to undefined functions are
+// resolved against; a nil library turns linking off.
+func SetLibrary(name string, lib fs.FS) {
+	libraryName = name
+	library = lib
+}
+
+// linkLibrary appends to commands every library module that defines a
+// function which is called, but not defined, by the commands parsed so far,
+// unless the program has a module of the same name.
+// Library modules may call each other, so this repeats until nothing new is
+// needed.
+func linkLibrary() {
+	if library == nil {
+		return
+	}
+	// a module of the program replaces the library module of that name
+	tried := make(map[string]bool)
+	for _, cmd := range commands {
+		tried[cmd.module] = true
+	}
+	for {
+		linked := false
+		for _, module := range undefinedModules() {
+			if tried[module] {
+				continue
+			}
+			tried[module] = true
+			if linkModule(module) {
+				linked = true
+			}
+		}
+		if !linked {
+			return
+		}
+	}
+}
+
+func linkModule(module string) bool {
+	filename := module + ".vm"
+	f, err := library.Open(filename)
+	if err != nil {
+		// not a library module; the call is simply left unresolved
+		return false
+	}
+	defer f.Close()
+	currentModule = module
+	currentFunction = ""
+	currentFilename = path.Join(libraryName, filename)
+	code, err := io.ReadAll(f)
+	if err != nil {
+		panic(err)
+	}
+	jack.SetSourceText(currentFilename, code)
+	jack.ForLinesInReader(bytes.NewReader(code), processLine)
+	return true
+}
+
+// undefinedModules returns, in sorted order, the modules of all functions
+// that are called but not defined.
+func undefinedModules() []string {
+	defined := make(map[string]bool)
+	for _, cmd := range commands {
+		if cmd.ctype == C_FUNCTION {
+			defined[cmd.arg1] = true
+		}
+	}
+	called := make(map[string]bool)
+	if config.Boot && config.Entry != "" {
+		called[config.Entry] = true
+	}
+	for _, cmd := range commands {
+		if cmd.ctype == C_CALL {
+			called[cmd.arg1] = true
+		}
+	}
+	modules := make(map[string]bool)
+	for function := range called {
+		if !defined[function] {
+			modules[functionModule(function)] = true
+		}
+	}
+	var result []string
+	for module := range modules {
+		result = append(result, module)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func functionModule(function string) string {
+	dotIdx := strings.Index(function, ".")
+	if dotIdx < 0 {
+		return function
+	}
+	return function[0:dotIdx]
+}
+
+// diagnostics collects the errors and warnings found in the VM code.
+var diagnostics = &jack.Diagnostics{}
+
+// Diagnostics returns the errors and warnings found in the VM files by the
+// last translation. A translation that succeeds may still have found
+// warnings to report.
+func Diagnostics() *jack.Diagnostics {
+	return diagnostics
+}
+
+// failed returns the diagnostics as an error if they hold errors.
+func failed() error {
+	if diagnostics.Errors() > 0 {
+		return diagnostics
+	}
+	return nil
+}
+
+// spanOf returns where cmd is in its source file: word of it if that is not
+// empty, otherwise the whole command.
+func spanOf(cmd command, word string) jack.Span {
+	if cmd.filename == "" {
+		// the boot call
+		return jack.Span{}
+	}
+	return jack.WordSpan(cmd.filename, cmd.lineno, cmd.origLine, word)
+}
+
+func errorAt(cmd command, word string, format string, a ...interface{}) {
+	diagnostics.Errorf(spanOf(cmd, word), format, a...)
+}
+
+func warningAt(cmd command, word string, format string, a ...interface{}) {
+	diagnostics.Warningf(spanOf(cmd, word), format, a...)
+}
+
+// checkLinks indexes the function declarations of all parsed commands and
+// reports duplicate definitions, calls to functions that do not exist and
+// calls that pass a different number of arguments than the first call to
+// the same function.
+func checkLinks() {
+	definitions := make(map[string]command)
+	for _, cmd := range commands {
+		if cmd.ctype != C_FUNCTION {
+			continue
+		}
+		if first, ok := definitions[cmd.arg1]; ok {
+			errorAt(cmd, cmd.arg1, "function %s already defined at %s:%d", cmd.arg1, first.filename, first.lineno)
+			continue
+		}
+		definitions[cmd.arg1] = cmd
+	}
+	if config.Boot && config.Entry != "" {
+		if _, ok := definitions[config.Entry]; !ok {
+			diagnostics.Errorf(jack.Span{}, "entry function %s is not defined", config.Entry)
+		}
+	}
+	firstCalls := make(map[string]command)
+	for _, cmd := range commands {
+		if cmd.ctype != C_CALL {
+			continue
+		}
+		if _, ok := definitions[cmd.arg1]; !ok {
+			errorAt(cmd, cmd.arg1, "call to undefined function %s", cmd.arg1)
+		}
+		first, ok := firstCalls[cmd.arg1]
+		if !ok {
+			firstCalls[cmd.arg1] = cmd
+			continue
+		}
+		if first.arg2 != cmd.arg2 {
+			warningAt(cmd, "", "%s called with %s arguments, but with %s at %s:%d",
+				cmd.arg1, cmd.arg2, first.arg2, first.filename, first.lineno)
+		}
+	}
+}
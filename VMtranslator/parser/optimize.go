@@ -0,0 +1,78 @@
+package parser
+
+import "strconv"
+
+// optimize rewrites the parsed commands according to the optimisations
+// enabled in config.
+func optimize() {
+	if config.InlineMath {
+		inlineMath()
+	}
+	if config.TailCalls {
+		markTailCalls()
+	}
+}
+
+// markTailCalls turns every call that is followed, labels aside, by a
+// return of the same function into a C_TAILCALL. The return stays in place
+// because a label in between may still jump to it.
+func markTailCalls() {
+	for i, cmd := range commands {
+		if cmd.ctype != C_CALL {
+			continue
+		}
+		for j := i + 1; j < len(commands) && commands[j].function == cmd.function; j++ {
+			if commands[j].ctype == C_LABEL {
+				continue
+			}
+			if commands[j].ctype == C_RETURN {
+				commands[i].ctype = C_TAILCALL
+			}
+			break
+		}
+	}
+}
+
+// inlineMath turns calls to Math.multiply and Math.divide into C_MULTIPLY
+// and C_DIVIDE. When the divisor, or either factor, is pushed as a constant
+// power of two right before the call, the push is folded into the command
+// as a shift.
+func inlineMath() {
+	for i, cmd := range commands {
+		if cmd.ctype != C_CALL || cmd.arg2 != "2" {
+			continue
+		}
+		switch cmd.arg1 {
+		case "Math.multiply":
+			commands[i].ctype = C_MULTIPLY
+		case "Math.divide":
+			commands[i].ctype = C_DIVIDE
+		default:
+			continue
+		}
+		commands[i].shift = -1
+		if shift, ok := constantShift(i - 1); ok {
+			commands[i-1].ctype = C_INLINED
+			commands[i].shift = shift
+		} else if shift, ok := constantShift(i - 2); ok && cmd.arg1 == "Math.multiply" && commands[i-1].ctype == C_PUSH {
+			// x * y == y * x, so a constant first factor works as well,
+			// as long as the second one is a plain push
+			commands[i-2].ctype = C_INLINED
+			commands[i].shift = shift
+		}
+	}
+}
+
+// constantShift reports whether commands[i] pushes a constant 2^shift.
+func constantShift(i int) (shift int, ok bool) {
+	if i < 0 || commands[i].ctype != C_PUSH || commands[i].arg1 != "constant" {
+		return 0, false
+	}
+	n, _ := strconv.Atoi(commands[i].arg2)
+	for shift = 0; shift < 15; shift++ {
+		if n == 1<<shift {
+			return shift, true
+		}
+	}
+	return 0, false
+}
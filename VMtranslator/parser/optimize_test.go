@@ -0,0 +1,131 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	assembler "jack/hackAssembler/parser"
+	"jack/hackEmulator/cpu"
+)
+
+// runAsm translates files to Hack assembly, assembles it and runs it on the
+// CPU emulator until it halts.
+func runAsm(t *testing.T, files map[string]string, cfg Config) *cpu.Machine {
+	t.Helper()
+	var asm bytes.Buffer
+	SetWriter(&asm)
+	_, err := ParseFiles(writeSources(t, files), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hack bytes.Buffer
+	_, err = assembler.Assemble(&asm, "Main.asm", &hack, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rom, err := cpu.Load(&hack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := cpu.New(rom)
+	m.Run(50000000)
+	if !m.Halted() {
+		t.Fatalf("program still running at ROM address %d", m.PC)
+	}
+	return m
+}
+
+// Main.main stores Main.sum(3000, 0) at 8000 and stops. Without tail calls
+// the 3000 frames of Main.sum need far more stack than the limit of
+// stackProgram allows.
+const stackMain = `function Main.main 0
+push constant 3000
+push constant 0
+call Main.sum 2
+pop temp 0
+push constant 8000
+pop pointer 1
+push temp 0
+pop that 0
+label END
+goto END
+`
+
+// sumFunction counts n down to 0 adding 1 to acc, with tail between the
+// recursive call and its return.
+func sumFunction(tail string) string {
+	return `function Main.sum 0
+push argument 0
+if-goto RECURSE
+push argument 1
+return
+label RECURSE
+push argument 0
+push constant 1
+sub
+push argument 1
+push constant 1
+add
+call Main.sum 2
+` + tail + `return
+`
+}
+
+func stackProgram(tailCalls bool) Config {
+	cfg := DefaultConfig()
+	cfg.Entry = "Main.main"
+	cfg.StackLimit = 2047
+	cfg.TailCalls = tailCalls
+	return cfg
+}
+
+var tailCallTests = []struct {
+	name string
+	tail string
+}{
+	{"return", ""},
+	{"label and return", "label DONE\n"},
+}
+
+func TestTailCallsAsm(t *testing.T) {
+	SetLibrary("", nil)
+	for _, test := range tailCallTests {
+		t.Run(test.name, func(t *testing.T) {
+			files := map[string]string{"Main.vm": stackMain + sumFunction(test.tail)}
+			m := runAsm(t, files, stackProgram(false))
+			if m.RAM[0] <= 2047 {
+				t.Errorf("without tail calls: SP = %d, want the stack to overflow past 2047", m.RAM[0])
+			}
+			m = runAsm(t, files, stackProgram(true))
+			// the boot call leaves SP at 256 + 5 in Main.main
+			if m.RAM[8000] != 3000 || m.RAM[0] != 261 {
+				t.Errorf("with tail calls: RAM[8000] = %d, SP = %d, want 3000 and 261", m.RAM[8000], m.RAM[0])
+			}
+		})
+	}
+}
+
+func TestTailCallsGo(t *testing.T) {
+	SetLibrary("", nil)
+	const main = `func main() {
+	m := NewMachine()
+	m.Run(0)
+	fmt.Println(m.StackOverflow, m.RAM[8000], m.RAM[SP])
+}
+`
+	for _, test := range tailCallTests {
+		t.Run(test.name, func(t *testing.T) {
+			files := map[string]string{"Main.vm": stackMain + sumFunction(test.tail)}
+			output := runGo(t, files, stackProgram(false), main)
+			if !strings.HasPrefix(output, "true 0 ") {
+				t.Errorf("without tail calls: got %q, want a stack overflow", output)
+			}
+			output = runGo(t, files, stackProgram(true), main)
+			if want := fmt.Sprint(false, 3000, 261); output != want {
+				t.Errorf("with tail calls: got %q, want %q", output, want)
+			}
+		})
+	}
+}
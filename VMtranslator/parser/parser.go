@@ -1,6 +1,7 @@
 package parser
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"jack"
@@ -20,6 +21,10 @@ const (
 	C_FUNCTION             = iota
 	C_RETURN               = iota
 	C_CALL                 = iota
+	C_TAILCALL             = iota // a call whose result is returned at once, see markTailCalls
+	C_MULTIPLY             = iota // call Math.multiply 2, inlined by inlineMath
+	C_DIVIDE               = iota // call Math.divide 2, inlined by inlineMath
+	C_INLINED              = iota // push constant folded into the C_MULTIPLY or C_DIVIDE after it
 )
 
 type command struct {
@@ -29,30 +34,96 @@ type command struct {
 	command  string
 	arg1     string
 	arg2     string
+	filename string
+	lineno   int
+	origLine string
+	shift    int // C_MULTIPLY or C_DIVIDE by 2^shift, -1 if the operand is not a constant
 }
 
 var currentModule string
 var currentFunction string
+var currentFilename string
+var commands []command
+var config Config
 var asmFile io.Writer
+var sources = make(map[string][]byte)
 
 func SetWriter(output io.Writer) {
 	asmFile = output
 }
 
-func ParseFiles(filenames []string, needBoot bool) {
-	if needBoot {
+// SetSource makes the parser read the VM code of filename from code instead
+// of from the file, so code that was never written to disk can be translated.
+func SetSource(filename string, code []byte) {
+	sources[filename] = code
+}
+
+// ParseFiles translates the VM files into Hack assembly written to the
+// writer of SetWriter. The returned map leads from the lines of the assembly
+// to the VM commands they were generated from.
+func ParseFiles(filenames []string, cfg Config) (*jack.SourceMap, error) {
+	config = cfg
+	err := parseFiles(filenames)
+	if err != nil {
+		return nil, err
+	}
+	optimize()
+	labelCounts = make(map[string]int)
+	asmLine = 0
+	sourceMap := &jack.SourceMap{}
+	if config.Boot {
 		writeBoot()
 	}
+	writeRoutines()
+	for _, cmd := range commands {
+		sourceMap.Add(asmLine+1, jack.Position{Filename: cmd.filename, Line: cmd.lineno})
+		write("// %s", cmd.origLine)
+		writeCode(cmd)
+	}
+	sourceMap.Add(asmLine+1, jack.Position{})
+	if config.StackLimit > 0 {
+		writeStackOverflowHandler()
+	}
+	return sourceMap, nil
+}
+
+// parseFiles parses the VM files, links the library and checks the links.
+// It fails with the *jack.Diagnostics of all problems found if any is an
+// error.
+func parseFiles(filenames []string) error {
+	diagnostics = &jack.Diagnostics{}
+	commands = nil
 	for _, filename := range filenames {
 		currentModule = moduleName(filename)
+		currentFunction = ""
 		parseFile(filename)
 	}
+	linkLibrary()
+	// commands with errors are left out, so their calls would be missed
+	if diagnostics.Errors() == 0 {
+		checkLinks()
+	}
+	return failed()
 }
 
 func parseFile(filename string) {
 	// fmt.Printf("parseFile called with filename:%s\n", filename)
 	// fmt.Printf("asmfile:%v\n", asmFile)
-	jack.ForLinesInFile(filename, processLine)
+	currentFilename = filename
+	code, ok := sources[filename]
+	if !ok {
+		r, err := jack.Open(filename)
+		if err != nil {
+			panic(err)
+		}
+		defer r.Close()
+		code, err = io.ReadAll(r)
+		if err != nil {
+			panic(err)
+		}
+	}
+	jack.SetSourceText(filename, code)
+	jack.ForLinesInReader(bytes.NewReader(code), processLine)
 }
 
 func parseCommand(line string) (cmd command, err error) {
@@ -86,7 +157,7 @@ func parseCommand(line string) (cmd command, err error) {
 	case "return":
 		cmd.ctype = C_RETURN
 	default:
-		err = fmt.Errorf("unrecognized command:%s", command)
+		err = fmt.Errorf("unrecognized command %s", command)
 		return
 	}
 	cmd.arg1, rest = nextWord(rest)
@@ -111,7 +182,7 @@ func vetCommand(cmd command) error {
 			return fmt.Errorf("too many arguments: %s takes one argument", cmd.command)
 		}
 		if matches := labelRegexp.MatchString(cmd.arg1); !matches {
-			return fmt.Errorf("invalid form of label:%s", cmd.arg1)
+			return fmt.Errorf("invalid form of label %s", cmd.arg1)
 		}
 	case C_CALL, C_FUNCTION, C_POP, C_PUSH:
 		if len(cmd.arg1) == 0 || len(cmd.arg2) == 0 {
@@ -122,7 +193,7 @@ func vetCommand(cmd command) error {
 		}
 		if cmd.ctype == C_CALL || cmd.ctype == C_FUNCTION {
 			if matches := labelRegexp.MatchString(cmd.arg1); !matches {
-				return fmt.Errorf("invalid form of function name:%s", cmd.arg1)
+				return fmt.Errorf("invalid form of function name %s", cmd.arg1)
 			}
 		} else {
 			// C_POP or C_PUSH
@@ -174,20 +245,39 @@ func processLine(line string, lineno int, origLine string) error {
 	if line == "" {
 		return nil
 	}
+	// a command with an error is reported and left out
+	span := jack.LineSpan(currentFilename, lineno, origLine)
 	cmd, err := parseCommand(line)
 	if err != nil {
-		return fmt.Errorf("ERROR:%v\n", err)
+		diagnostics.Errorf(span, "%v", err)
+		return nil
 	}
 	err = vetCommand(cmd)
 	if err != nil {
-		return err
+		diagnostics.Errorf(span, "%v", err)
+		return nil
+	}
+	if cmd.ctype == C_FUNCTION {
+		currentFunction = cmd.arg1
+		cmd.function = currentFunction
+		if currentFilename == jack.Stdio {
+			// standard input may hold several modules one after the other
+			currentModule = functionModule(cmd.arg1)
+			cmd.module = currentModule
+		}
 	}
-	write("// %s", origLine)
-	writeCode(cmd)
+	cmd.filename = currentFilename
+	cmd.lineno = lineno
+	cmd.origLine = origLine
+	commands = append(commands, cmd)
 	return nil
 }
 
 func moduleName(filename string) string {
+	if filename == jack.Stdio {
+		// set by every function declaration instead
+		return ""
+	}
 	base := filepath.Base(filename)
 	return base[0 : len(base)-3]
 }
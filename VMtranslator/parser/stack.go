@@ -0,0 +1,232 @@
+package parser
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// frameSize is the number of words writeCall pushes between the arguments
+// of a call and the callee's locals.
+const frameSize = 5
+
+type callSite struct {
+	callee string
+	height int // working stack height before the call, arguments included
+}
+
+// functionStack is what the analysis learns about one VM function. Heights
+// are counted in words above the function's locals.
+type functionStack struct {
+	name      string
+	nLocals   int
+	maxHeight int
+	calls     []callSite
+}
+
+type stackAnalysis struct {
+	functions map[string]*functionStack
+	order     []string
+	depths    map[string]int
+	visiting  map[string]bool
+}
+
+// AnalyzeFiles parses the VM files and writes a report of the stack height
+// of every function to wrt: where the height differs between the paths that
+// join at a label, where a command would pop an empty stack, and how deep
+// the stack can grow in each function and in the whole program.
+func AnalyzeFiles(filenames []string, cfg Config, wrt io.Writer) error {
+	config = cfg
+	err := parseFiles(filenames)
+	if err != nil {
+		return err
+	}
+	analysis := analyzeStack()
+	analysis.writeReport(wrt)
+	return failed()
+}
+
+func analyzeStack() *stackAnalysis {
+	analysis := &stackAnalysis{
+		functions: make(map[string]*functionStack),
+		depths:    make(map[string]int),
+		visiting:  make(map[string]bool),
+	}
+	start := -1
+	for i, cmd := range commands {
+		if cmd.ctype != C_FUNCTION {
+			continue
+		}
+		if start >= 0 {
+			analysis.analyzeFunction(start, i)
+		}
+		start = i
+	}
+	if start >= 0 {
+		analysis.analyzeFunction(start, len(commands))
+	}
+	return analysis
+}
+
+// stackEffect returns how many words cmd pops and then pushes.
+func stackEffect(cmd command) (pops, pushes int) {
+	switch cmd.ctype {
+	case C_ARITHMETIC:
+		if cmd.command == "neg" || cmd.command == "not" {
+			return 1, 1
+		}
+		return 2, 1
+	case C_PUSH:
+		return 0, 1
+	case C_POP, C_IF:
+		return 1, 0
+	case C_CALL:
+		n, _ := strconv.Atoi(cmd.arg2)
+		return n, 1
+	case C_RETURN:
+		return 1, 0
+	}
+	return 0, 0
+}
+
+// analyzeFunction walks the control-flow graph of commands[start:end],
+// which holds a single function, and records the stack height before each
+// command.
+func (a *stackAnalysis) analyzeFunction(start, end int) {
+	fn := &functionStack{name: commands[start].arg1}
+	fn.nLocals, _ = strconv.Atoi(commands[start].arg2)
+	a.functions[fn.name] = fn
+	a.order = append(a.order, fn.name)
+
+	labels := make(map[string]int)
+	for i := start + 1; i < end; i++ {
+		if commands[i].ctype == C_LABEL {
+			labels[commands[i].arg1] = i
+		}
+	}
+	heights := make(map[int]int)
+	reported := make(map[int]bool)
+	worklist := []int{start + 1}
+	heights[start+1] = 0
+	flowTo := func(from, to, height int) {
+		if to >= end {
+			cmd := commands[from]
+			warningAt(cmd, "", "control reaches the end of %s without a return", fn.name)
+			return
+		}
+		known, ok := heights[to]
+		if !ok {
+			heights[to] = height
+			worklist = append(worklist, to)
+			return
+		}
+		if known != height && !reported[to] {
+			reported[to] = true
+			cmd := commands[to]
+			warningAt(cmd, "", "stack height at %s is %d on one path and %d on another",
+				cmd.origLine, known, height)
+		}
+	}
+	for len(worklist) > 0 {
+		i := worklist[len(worklist)-1]
+		worklist = worklist[:len(worklist)-1]
+		cmd := commands[i]
+		height := heights[i]
+		pops, pushes := stackEffect(cmd)
+		if height < pops {
+			if cmd.ctype == C_RETURN {
+				warningAt(cmd, "", "return with an empty stack in %s", fn.name)
+			} else {
+				warningAt(cmd, "", "%s needs %d values but the stack holds %d",
+					cmd.origLine, pops, height)
+			}
+			height = pops
+		}
+		if cmd.ctype == C_CALL {
+			fn.calls = append(fn.calls, callSite{callee: cmd.arg1, height: height})
+		}
+		height = height - pops + pushes
+		if height > fn.maxHeight {
+			fn.maxHeight = height
+		}
+		switch cmd.ctype {
+		case C_RETURN:
+			// no successor
+		case C_GOTO, C_IF:
+			target, ok := labels[cmd.arg1]
+			if !ok {
+				errorAt(cmd, cmd.arg1, "label %s is not defined in %s", cmd.arg1, fn.name)
+			} else {
+				flowTo(i, target, height)
+			}
+			if cmd.ctype == C_IF {
+				flowTo(i, i+1, height)
+			}
+		default:
+			flowTo(i, i+1, height)
+		}
+	}
+}
+
+// depth returns the number of words the stack can grow by from the moment
+// name is called, counting its locals, working stack and everything its
+// callees push. ok is false if name is recursive, directly or not.
+func (a *stackAnalysis) depth(name string) (depth int, ok bool) {
+	if d, done := a.depths[name]; done {
+		return d, d >= 0
+	}
+	fn, defined := a.functions[name]
+	if !defined {
+		// undefined functions are reported by checkLinks
+		return 0, true
+	}
+	if a.visiting[name] {
+		return 0, false
+	}
+	a.visiting[name] = true
+	depth = fn.maxHeight
+	ok = true
+	for _, call := range fn.calls {
+		calleeDepth, calleeOk := a.depth(call.callee)
+		if !calleeOk {
+			ok = false
+			continue
+		}
+		if d := call.height + frameSize + calleeDepth; d > depth {
+			depth = d
+		}
+	}
+	depth += fn.nLocals
+	delete(a.visiting, name)
+	if !ok {
+		// a function on the cycle may be finished before the cycle is
+		// found, so only remember that it is unbounded
+		a.depths[name] = -1
+		return 0, false
+	}
+	a.depths[name] = depth
+	return depth, true
+}
+
+func (a *stackAnalysis) writeReport(wrt io.Writer) {
+	fmt.Fprintf(wrt, "%-40s %6s %6s %10s\n", "function", "locals", "stack", "max depth")
+	for _, name := range a.order {
+		fn := a.functions[name]
+		depth, ok := a.depth(name)
+		depthString := strconv.Itoa(depth)
+		if !ok {
+			depthString = "recursive"
+		}
+		fmt.Fprintf(wrt, "%-40s %6d %6d %10s\n", name, fn.nLocals, fn.maxHeight, depthString)
+	}
+	if !config.Boot || config.Entry == "" {
+		return
+	}
+	depth, ok := a.depth(config.Entry)
+	if !ok {
+		fmt.Fprintf(wrt, "program: unbounded, %s reaches a recursive function\n", config.Entry)
+		return
+	}
+	depth += frameSize
+	fmt.Fprintf(wrt, "program: at most %d words of stack, SP stays at or below %d\n", depth, config.StackPointer+depth)
+}
@@ -0,0 +1,474 @@
+package parser
+
+import (
+	"jack"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RAM addresses of the virtual registers.
+const (
+	SP   = 0
+	LCL  = 1
+	ARG  = 2
+	THIS = 3
+	THAT = 4
+)
+
+// KBD is the RAM address of the keyboard, which holds the code of the key
+// pressed, 0 for none.
+const KBD = 24576
+
+// VM runs a VM program one command per Step, with the same RAM layout as
+// the Hack platform and the frames laid out by writeCall, so the machine
+// can be inspected at the level of VM commands.
+type VM struct {
+	RAM           [32768]int16
+	PC            int  // index of the next command
+	Depth         int  // number of frames on the call stack
+	StackOverflow bool // a function was entered with SP past the stack limit
+	halted        bool
+	program       *goProgram
+	config        Config
+
+	// loop detection, see checkLoop
+	waiting       bool
+	snapshot      [32768]int16
+	snapshotPC    int
+	differences   int
+	sinceSnapshot int
+}
+
+// snapshotInterval is the number of steps after which checkLoop takes a new
+// snapshot of the machine.
+const snapshotInterval = 1 << 16
+
+// Frame is a function activation on the call stack.
+type Frame struct {
+	Function string
+	PC       int // the current command, or the call in progress
+	LCL      int16
+	ARG      int16
+	THIS     int16
+	THAT     int16
+}
+
+// LoadVM parses and links the VM files like ParseFiles and returns a
+// machine ready to run them.
+func LoadVM(filenames []string, cfg Config) (*VM, error) {
+	config = cfg
+	program, err := loadProgram(filenames)
+	if err != nil {
+		return nil, err
+	}
+	vm := &VM{program: program, config: cfg}
+	vm.Reset()
+	return vm, nil
+}
+
+func (vm *VM) Reset() {
+	vm.RAM = [32768]int16{}
+	vm.PC = 0
+	vm.Depth = 0
+	vm.StackOverflow = false
+	vm.halted = false
+	vm.waiting = false
+	if vm.config.Boot {
+		addresses := map[string]int{"SP": SP, "LCL": LCL, "ARG": ARG, "THIS": THIS, "THAT": THAT}
+		for _, reg := range vm.config.presetRegisters() {
+			vm.RAM[addresses[reg.name]] = int16(reg.value)
+		}
+	}
+	vm.takeSnapshot()
+}
+
+// Halted reports whether the machine has stopped: the program ran off its
+// end, overflowed the stack or divided by zero, or it waits in a loop that
+// it cannot leave without input, such as the one of Sys.halt or one polling
+// the keyboard. A wait is only a pause, the next Step carries on.
+func (vm *VM) Halted() bool {
+	return vm.halted || vm.waiting || vm.PC < 0 || vm.PC >= len(vm.program.commands)
+}
+
+// NumCommands returns the number of commands of the program.
+func (vm *VM) NumCommands() int {
+	return len(vm.program.commands)
+}
+
+// Position returns the file and line command pc was read from, the zero
+// Position for the boot call.
+func (vm *VM) Position(pc int) jack.Position {
+	cmd := vm.program.commands[pc]
+	return jack.Position{Filename: cmd.filename, Line: cmd.lineno}
+}
+
+// Source returns the text of command pc as it was written.
+func (vm *VM) Source(pc int) string {
+	return strings.TrimSpace(vm.program.commands[pc].origLine)
+}
+
+// Function returns the function command pc belongs to.
+func (vm *VM) Function(pc int) string {
+	return vm.program.commands[pc].function
+}
+
+// Module returns the module command pc belongs to.
+func (vm *VM) Module(pc int) string {
+	return vm.program.commands[pc].module
+}
+
+// FunctionPC returns the index of the 'function' command of function.
+func (vm *VM) FunctionPC(function string) (pc int, ok bool) {
+	pc, ok = vm.program.functions[function]
+	return
+}
+
+// LinePC returns the index of the first command read from filename at line
+// or after it. A file name without directory matches a file of that name in
+// any directory.
+func (vm *VM) LinePC(filename string, line int) (pc int, ok bool) {
+	for pc, cmd := range vm.program.commands {
+		if cmd.lineno < line || cmd.filename == "" {
+			continue
+		}
+		if cmd.filename == filename || (filepath.Base(filename) == filename && filepath.Base(cmd.filename) == filename) {
+			return pc, true
+		}
+	}
+	return 0, false
+}
+
+func address(value int16) int {
+	return int(uint16(value)) & 0x7fff
+}
+
+func truth(b bool) int16 {
+	if b {
+		return -1
+	}
+	return 0
+}
+
+// write stores value at address, counting the words that differ from the
+// snapshot of checkLoop.
+func (vm *VM) write(address int, value int16) {
+	if vm.RAM[address] == vm.snapshot[address] && value != vm.snapshot[address] {
+		vm.differences++
+	} else if vm.RAM[address] != vm.snapshot[address] && value == vm.snapshot[address] {
+		vm.differences--
+	}
+	vm.RAM[address] = value
+}
+
+// checkLoop notices when the machine gets back into the state of its last
+// snapshot, as the generated Machine does. The keyboard is compared as well,
+// as it is set from outside without write.
+func (vm *VM) checkLoop() {
+	if vm.PC == vm.snapshotPC && vm.differences == 0 && vm.RAM[KBD] == vm.snapshot[KBD] {
+		vm.waiting = true
+		return
+	}
+	vm.sinceSnapshot++
+	if vm.sinceSnapshot >= snapshotInterval {
+		vm.takeSnapshot()
+	}
+}
+
+func (vm *VM) takeSnapshot() {
+	vm.snapshot = vm.RAM
+	vm.snapshotPC = vm.PC
+	vm.differences = 0
+	vm.sinceSnapshot = 0
+}
+
+func (vm *VM) push(value int16) {
+	vm.write(address(vm.RAM[SP]), value)
+	vm.write(SP, vm.RAM[SP]+1)
+}
+
+func (vm *VM) pop() int16 {
+	vm.write(SP, vm.RAM[SP]-1)
+	return vm.RAM[address(vm.RAM[SP])]
+}
+
+// location returns the RAM address of a segment other than constant.
+func (vm *VM) location(cmd command) int {
+	index, _ := strconv.Atoi(cmd.arg2)
+	switch cmd.arg1 {
+	case "local":
+		return address(vm.RAM[LCL] + int16(index))
+	case "argument":
+		return address(vm.RAM[ARG] + int16(index))
+	case "this":
+		return address(vm.RAM[THIS] + int16(index))
+	case "that":
+		return address(vm.RAM[THAT] + int16(index))
+	case "pointer":
+		return 3 + index
+	case "temp":
+		return 5 + index
+	case "static":
+		return vm.program.statics[cmd.module+"."+cmd.arg2]
+	}
+	return 0
+}
+
+func (vm *VM) call(target int, nArgs int16) {
+	vm.push(int16(vm.PC + 1))
+	vm.push(vm.RAM[LCL])
+	vm.push(vm.RAM[ARG])
+	vm.push(vm.RAM[THIS])
+	vm.push(vm.RAM[THAT])
+	vm.write(ARG, vm.RAM[SP]-nArgs-frameSize)
+	vm.write(LCL, vm.RAM[SP])
+	vm.PC = target
+	vm.Depth++
+}
+
+// tailCall calls target in place of the current function, reusing its frame.
+func (vm *VM) tailCall(target int, nArgs int16) {
+	frame := vm.RAM[LCL]
+	for offset := int16(frameSize); offset > 0; offset-- {
+		vm.push(vm.RAM[address(frame-offset)])
+	}
+	source := vm.RAM[SP] - nArgs - frameSize
+	destination := vm.RAM[ARG]
+	for i := int16(0); i < nArgs+frameSize; i++ {
+		vm.write(address(destination+i), vm.RAM[address(source+i)])
+	}
+	vm.write(LCL, destination+nArgs+frameSize)
+	vm.write(SP, vm.RAM[LCL])
+	vm.PC = target
+}
+
+func (vm *VM) ret() {
+	frame := vm.RAM[LCL]
+	returnAddress := vm.RAM[address(frame-5)]
+	vm.write(address(vm.RAM[ARG]), vm.pop())
+	vm.write(SP, vm.RAM[ARG]+1)
+	vm.write(THAT, vm.RAM[address(frame-1)])
+	vm.write(THIS, vm.RAM[address(frame-2)])
+	vm.write(ARG, vm.RAM[address(frame-3)])
+	vm.write(LCL, vm.RAM[address(frame-4)])
+	vm.PC = int(uint16(returnAddress))
+	vm.Depth--
+}
+
+// Step executes the command at PC, doing what the code of TranslateToGo
+// does for it. After a wait it takes up the program again.
+func (vm *VM) Step() {
+	if vm.waiting {
+		vm.waiting = false
+		vm.takeSnapshot()
+	}
+	if vm.Halted() {
+		return
+	}
+	vm.step()
+	vm.checkLoop()
+}
+
+func (vm *VM) step() {
+	p := vm.program
+	cmd := p.commands[vm.PC]
+	switch cmd.ctype {
+	case C_ARITHMETIC:
+		vm.arithmetic(cmd.command)
+	case C_PUSH:
+		if cmd.arg1 == "constant" {
+			n, _ := strconv.Atoi(cmd.arg2)
+			vm.push(int16(n))
+		} else {
+			vm.push(vm.RAM[vm.location(cmd)])
+		}
+	case C_POP:
+		value := vm.pop()
+		vm.write(vm.location(cmd), value)
+	case C_GOTO:
+		target := p.labels[labelKey(cmd.function, cmd.arg1)]
+		if p.isSelfLoop(target, vm.PC) {
+			vm.halted = true
+		}
+		vm.PC = target
+		return
+	case C_IF:
+		if vm.pop() != 0 {
+			vm.PC = p.labels[labelKey(cmd.function, cmd.arg1)]
+			return
+		}
+	case C_FUNCTION:
+		n, _ := strconv.Atoi(cmd.arg2)
+		for i := 0; i < n; i++ {
+			vm.push(0)
+		}
+		if vm.config.StackLimit > 0 && int(vm.RAM[SP]) > vm.config.StackLimit {
+			vm.StackOverflow = true
+			vm.halted = true
+			return
+		}
+	case C_CALL:
+		n, _ := strconv.Atoi(cmd.arg2)
+		vm.call(p.functions[cmd.arg1], int16(n))
+		return
+	case C_TAILCALL:
+		n, _ := strconv.Atoi(cmd.arg2)
+		vm.tailCall(p.functions[cmd.arg1], int16(n))
+		return
+	case C_MULTIPLY:
+		if cmd.shift >= 0 {
+			vm.push(vm.pop() << cmd.shift)
+		} else {
+			y := vm.pop()
+			vm.push(vm.pop() * y)
+		}
+	case C_DIVIDE:
+		var y int16
+		if cmd.shift >= 0 {
+			y = 1 << cmd.shift
+		} else {
+			y = vm.pop()
+		}
+		if y == 0 {
+			vm.halted = true
+			return
+		}
+		vm.push(vm.pop() / y)
+	case C_RETURN:
+		vm.ret()
+		return
+	}
+	vm.PC++
+}
+
+func (vm *VM) arithmetic(operation string) {
+	switch operation {
+	case "neg":
+		vm.push(-vm.pop())
+	case "not":
+		vm.push(^vm.pop())
+	case "add":
+		vm.push(vm.pop() + vm.pop())
+	case "and":
+		vm.push(vm.pop() & vm.pop())
+	case "or":
+		vm.push(vm.pop() | vm.pop())
+	case "eq":
+		vm.push(truth(vm.pop() == vm.pop()))
+	case "sub":
+		y := vm.pop()
+		vm.push(vm.pop() - y)
+	case "gt":
+		y := vm.pop()
+		vm.push(truth(vm.pop() > y))
+	case "lt":
+		y := vm.pop()
+		vm.push(truth(vm.pop() < y))
+	}
+}
+
+// Frames walks the call stack from the current function outwards, following
+// the return address and the LCL, ARG, THIS and THAT of the caller that
+// every call saves below the frame of the callee. The boot call is left out.
+func (vm *VM) Frames() []Frame {
+	frames := []Frame{{
+		PC:   vm.PC,
+		LCL:  vm.RAM[LCL],
+		ARG:  vm.RAM[ARG],
+		THIS: vm.RAM[THIS],
+		THAT: vm.RAM[THAT],
+	}}
+	for i := 0; i < vm.Depth; i++ {
+		frame := frames[len(frames)-1].LCL
+		returnAddress := int(uint16(vm.RAM[address(frame-5)]))
+		if returnAddress < 1 || returnAddress > len(vm.program.commands) {
+			break
+		}
+		frames = append(frames, Frame{
+			PC:   returnAddress - 1,
+			LCL:  vm.RAM[address(frame-4)],
+			ARG:  vm.RAM[address(frame-3)],
+			THIS: vm.RAM[address(frame-2)],
+			THAT: vm.RAM[address(frame-1)],
+		})
+	}
+	var result []Frame
+	for _, f := range frames {
+		if f.PC >= len(vm.program.commands) {
+			// the program has run off its end
+			continue
+		}
+		f.Function = vm.Function(f.PC)
+		if f.Function == "Boot" {
+			break
+		}
+		result = append(result, f)
+	}
+	return result
+}
+
+// NumLocals returns the number of local variables of function.
+func (vm *VM) NumLocals(function string) int {
+	pc, ok := vm.program.functions[function]
+	if !ok {
+		return 0
+	}
+	n, _ := strconv.Atoi(vm.program.commands[pc].arg2)
+	return n
+}
+
+// NumArgs returns the number of arguments f was called with, which is what
+// lies between its ARG and the saved frame below its LCL.
+func (f Frame) NumArgs() int {
+	return int(f.LCL - f.ARG - frameSize)
+}
+
+// WorkingStack returns the values pushed by the current function that are
+// still on the stack, the top last.
+func (vm *VM) WorkingStack() []int16 {
+	var stack []int16
+	if vm.PC >= len(vm.program.commands) {
+		return nil
+	}
+	var start int
+	switch function := vm.Function(vm.PC); {
+	case function == "" || function == "Boot":
+		start = vm.config.StackPointer
+	case vm.program.commands[vm.PC].ctype == C_FUNCTION:
+		// the locals are not pushed yet
+		start = int(vm.RAM[LCL])
+	default:
+		start = int(vm.RAM[LCL]) + vm.NumLocals(function)
+	}
+	for a := start; a < int(vm.RAM[SP]); a++ {
+		stack = append(stack, vm.RAM[address(int16(a))])
+	}
+	return stack
+}
+
+// Static is a static variable with its RAM address.
+type Static struct {
+	Index   int
+	Address int
+}
+
+// Statics returns the static variables of module in order of their index.
+func (vm *VM) Statics(module string) []Static {
+	var statics []Static
+	prefix := module + "."
+	for name, address := range vm.program.statics {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		index, err := strconv.Atoi(name[len(prefix):])
+		if err != nil {
+			continue
+		}
+		statics = append(statics, Static{Index: index, Address: address})
+	}
+	sort.Slice(statics, func(i, j int) bool {
+		return statics[i].Index < statics[j].Index
+	})
+	return statics
+}
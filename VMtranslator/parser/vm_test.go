@@ -0,0 +1,82 @@
+package parser
+
+import (
+	"testing"
+
+	"jack/VMtranslator/oslib"
+)
+
+// runVM steps vm until it halts or maxSteps commands have run.
+func runVM(t *testing.T, vm *VM, maxSteps int) {
+	t.Helper()
+	for steps := 0; !vm.Halted(); steps++ {
+		if steps == maxSteps {
+			t.Fatalf("still running after %d steps at %s", maxSteps, vm.Source(vm.PC))
+		}
+		vm.Step()
+	}
+}
+
+func loadVM(t *testing.T, files map[string]string) *VM {
+	t.Helper()
+	SetLibrary("oslib", oslib.FS)
+	t.Cleanup(func() { SetLibrary("", nil) })
+	vm, err := LoadVM(writeSources(t, files), DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return vm
+}
+
+func TestVMHaltsInSysHalt(t *testing.T) {
+	vm := loadVM(t, map[string]string{
+		"Main.vm": `function Main.main 0
+push constant 8000
+push constant 7
+push constant 6
+call Math.multiply 2
+call Memory.poke 2
+pop temp 0
+push constant 0
+return
+`,
+	})
+	runVM(t, vm, 1000000)
+	if vm.RAM[8000] != 42 {
+		t.Errorf("RAM[8000] = %d, want 42", vm.RAM[8000])
+	}
+	if function := vm.Function(vm.PC); function != "Sys.halt" {
+		t.Errorf("halted in %s, want Sys.halt", function)
+	}
+}
+
+func TestVMWaitsForKey(t *testing.T) {
+	// Main.main waits for a key and stores its code at 8000
+	vm := loadVM(t, map[string]string{
+		"Main.vm": `function Main.main 1
+label WAIT
+call Keyboard.keyPressed 0
+pop local 0
+push local 0
+push constant 0
+eq
+if-goto WAIT
+push constant 8000
+push local 0
+call Memory.poke 2
+pop temp 0
+push constant 0
+return
+`,
+	})
+	runVM(t, vm, 1000000)
+	if vm.RAM[8000] != 0 || vm.Function(vm.PC) == "Sys.halt" {
+		t.Fatalf("stopped in %s with RAM[8000] = %d, want a wait for the key", vm.Function(vm.PC), vm.RAM[8000])
+	}
+	vm.RAM[KBD] = 65
+	vm.Step()
+	runVM(t, vm, 1000000)
+	if vm.RAM[8000] != 65 {
+		t.Errorf("RAM[8000] = %d after the key was pressed, want 65", vm.RAM[8000])
+	}
+}
@@ -0,0 +1,333 @@
+package jack
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Severity says whether a diagnostic stops a tool from producing output.
+type Severity int
+
+const (
+	Error Severity = iota
+	Warning
+)
+
+func (s Severity) String() string {
+	if s == Warning {
+		return "warning"
+	}
+	return "error"
+}
+
+// Span is a range of columns on a line of a source file. Columns count
+// bytes from 1; a Column of 0 stands for the whole line and a Line of 0 for
+// no line at all, such as a problem of the program as a whole.
+type Span struct {
+	Filename  string
+	Line      int
+	Column    int
+	EndColumn int // the column after the span
+}
+
+// LineSpan returns the span of line lineno of filename, which reads
+// origLine, without its leading and trailing blanks and its comment.
+func LineSpan(filename string, lineno int, origLine string) Span {
+	line := trimLine(origLine)
+	if line == "" {
+		return Span{Filename: filename, Line: lineno}
+	}
+	column := strings.Index(origLine, line) + 1
+	return Span{Filename: filename, Line: lineno, Column: column, EndColumn: column + len(line)}
+}
+
+// WordSpan returns the span of word on origLine, where it must stand
+// between blanks, or the whole line if it is not there.
+func WordSpan(filename string, lineno int, origLine string, word string) Span {
+	for start := 0; word != ""; {
+		idx := strings.Index(origLine[start:], word)
+		if idx < 0 {
+			break
+		}
+		idx += start
+		end := idx + len(word)
+		if (idx == 0 || isBlank(origLine[idx-1])) && (end == len(origLine) || isBlank(origLine[end])) {
+			return Span{Filename: filename, Line: lineno, Column: idx + 1, EndColumn: end + 1}
+		}
+		start = idx + 1
+	}
+	return LineSpan(filename, lineno, origLine)
+}
+
+func isBlank(c byte) bool {
+	return c == ' ' || c == '\t'
+}
+
+func (s Span) String() string {
+	switch {
+	case s.Line == 0:
+		return s.Filename
+	case s.Column == 0:
+		return fmt.Sprintf("%s:%d", s.Filename, s.Line)
+	}
+	return fmt.Sprintf("%s:%d:%d", s.Filename, s.Line, s.Column)
+}
+
+// Diagnostic is an error or a warning about a span of source code.
+type Diagnostic struct {
+	Severity Severity
+	Span
+	Message string
+}
+
+// Diagnostics collects the errors and warnings a tool finds, so that they
+// can all be reported at once.
+type Diagnostics struct {
+	list []Diagnostic
+}
+
+func (d *Diagnostics) Errorf(span Span, format string, a ...interface{}) {
+	d.list = append(d.list, Diagnostic{Severity: Error, Span: span, Message: fmt.Sprintf(format, a...)})
+}
+
+func (d *Diagnostics) Warningf(span Span, format string, a ...interface{}) {
+	d.list = append(d.list, Diagnostic{Severity: Warning, Span: span, Message: fmt.Sprintf(format, a...)})
+}
+
+// Append adds the diagnostics collected by other to those of d.
+func (d *Diagnostics) Append(other *Diagnostics) {
+	d.list = append(d.list, other.list...)
+}
+
+// Errors returns the number of errors collected.
+func (d *Diagnostics) Errors() int {
+	errors := 0
+	for _, diag := range d.list {
+		if diag.Severity == Error {
+			errors++
+		}
+	}
+	return errors
+}
+
+// Error lists the diagnostics collected, one per line, so that diagnostics
+// with errors can be returned as an error. Report prints them in full.
+func (d *Diagnostics) Error() string {
+	var lines []string
+	for _, diag := range d.list {
+		if diag.Filename == "" {
+			lines = append(lines, fmt.Sprintf("%v: %s", diag.Severity, diag.Message))
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%v: %v: %s", diag.Span, diag.Severity, diag.Message))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Report prints the diagnostics collected, those of each file in line
+// order and the files in the order they were first reported on, and
+// forgets them. It fails if any of them is an error.
+func (d *Diagnostics) Report() error {
+	files := make(map[string]int)
+	for _, diag := range d.list {
+		if _, ok := files[diag.Filename]; !ok {
+			files[diag.Filename] = len(files)
+		}
+	}
+	sort.SliceStable(d.list, func(i, j int) bool {
+		a, b := d.list[i].Span, d.list[j].Span
+		if a.Filename != b.Filename {
+			return files[a.Filename] < files[b.Filename]
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	for _, diag := range d.list {
+		if diagnosticFormat == JSONDiagnostics {
+			writeJSONDiagnostic(diag)
+		} else {
+			writeTextDiagnostic(diag)
+		}
+	}
+	errors := d.Errors()
+	d.list = nil
+	if errors > 0 {
+		return fmt.Errorf("%d error(s)", errors)
+	}
+	return nil
+}
+
+// DiagnosticFormat is how diagnostics are printed.
+type DiagnosticFormat int
+
+const (
+	// TextDiagnostics prints file:line:column: severity: message and the
+	// source line with a caret under the span, for people.
+	TextDiagnostics DiagnosticFormat = iota
+	// JSONDiagnostics prints a JSON object per line, for editors.
+	JSONDiagnostics
+)
+
+var diagnosticWriter io.Writer = os.Stderr
+var diagnosticFormat DiagnosticFormat
+var diagnosticColor bool
+
+// SetDiagnostics sets where and how Report prints diagnostics. Color
+// highlights the text format with ANSI escape sequences.
+func SetDiagnostics(w io.Writer, format DiagnosticFormat, color bool) {
+	diagnosticWriter = w
+	diagnosticFormat = format
+	diagnosticColor = color
+}
+
+// sourceTexts holds the sources registered by SetSourceText and those read
+// by Report, by file name. Files may be registered in parallel.
+var sourceTexts = make(map[string][]string)
+var sourceTextsMutex sync.Mutex
+
+// SetSourceText gives Report the text of filename, for sources that cannot
+// be read from disk, such as standard input.
+func SetSourceText(filename string, text []byte) {
+	sourceTextsMutex.Lock()
+	defer sourceTextsMutex.Unlock()
+	sourceTexts[filename] = strings.Split(string(text), "\n")
+}
+
+// sourceLine returns line lineno of filename, if it can be found.
+func sourceLine(filename string, lineno int) (string, bool) {
+	sourceTextsMutex.Lock()
+	defer sourceTextsMutex.Unlock()
+	lines, ok := sourceTexts[filename]
+	if !ok {
+		text, err := os.ReadFile(filename)
+		if err == nil && filename != Stdio {
+			lines = strings.Split(string(text), "\n")
+		}
+		sourceTexts[filename] = lines
+	}
+	if lineno < 1 || lineno > len(lines) {
+		return "", false
+	}
+	return strings.TrimRight(lines[lineno-1], "\r"), true
+}
+
+const (
+	colorBold    = "\x1b[1m"
+	colorRed     = "\x1b[1;31m"
+	colorMagenta = "\x1b[1;35m"
+	colorGreen   = "\x1b[1;32m"
+	colorReset   = "\x1b[0m"
+)
+
+func colored(color, s string) string {
+	if !diagnosticColor {
+		return s
+	}
+	return color + s + colorReset
+}
+
+func writeTextDiagnostic(diag Diagnostic) {
+	severityColor := colorRed
+	if diag.Severity == Warning {
+		severityColor = colorMagenta
+	}
+	if diag.Filename == "" {
+		fmt.Fprintf(diagnosticWriter, "%s: %s\n", colored(severityColor, diag.Severity.String()), diag.Message)
+		return
+	}
+	fmt.Fprintf(diagnosticWriter, "%s: %s: %s\n", colored(colorBold, diag.Span.String()),
+		colored(severityColor, diag.Severity.String()), diag.Message)
+	line, ok := sourceLine(diag.Filename, diag.Line)
+	if !ok || diag.Line == 0 {
+		return
+	}
+	fmt.Fprintf(diagnosticWriter, "%s\n", line)
+	if diag.Column == 0 {
+		return
+	}
+	// the caret line copies the tabs of the source line so that it lines up
+	var indent strings.Builder
+	for i := 0; i < diag.Column-1; i++ {
+		if i < len(line) && line[i] == '\t' {
+			indent.WriteByte('\t')
+		} else {
+			indent.WriteByte(' ')
+		}
+	}
+	marker := "^"
+	if diag.EndColumn > diag.Column+1 {
+		marker += strings.Repeat("~", diag.EndColumn-diag.Column-1)
+	}
+	fmt.Fprintf(diagnosticWriter, "%s%s\n", indent.String(), colored(colorGreen, marker))
+}
+
+// jsonDiagnostic is the form of a diagnostic in the JSON format. Fields
+// that are not known are left out.
+type jsonDiagnostic struct {
+	File      string `json:"file,omitempty"`
+	Line      int    `json:"line,omitempty"`
+	Column    int    `json:"column,omitempty"`
+	EndColumn int    `json:"endColumn,omitempty"`
+	Severity  string `json:"severity"`
+	Message   string `json:"message"`
+}
+
+func writeJSONDiagnostic(diag Diagnostic) {
+	data, err := json.Marshal(jsonDiagnostic{
+		File:      diag.Filename,
+		Line:      diag.Line,
+		Column:    diag.Column,
+		EndColumn: diag.EndColumn,
+		Severity:  diag.Severity.String(),
+		Message:   diag.Message,
+	})
+	if err != nil {
+		panic(err)
+	}
+	fmt.Fprintf(diagnosticWriter, "%s\n", data)
+}
+
+// DiagnosticFlags are the command line options that say how diagnostics
+// are printed, shared by all tools.
+type DiagnosticFlags struct {
+	json  bool
+	color string
+}
+
+func AddDiagnosticFlags(flags *flag.FlagSet) *DiagnosticFlags {
+	d := &DiagnosticFlags{}
+	flags.BoolVar(&d.json, "json", false, "print errors and warnings as JSON objects, one per line")
+	flags.StringVar(&d.color, "color", "auto", "highlight errors and warnings: auto, always or never")
+	return d
+}
+
+// Setup applies the options with SetDiagnostics. With -color=auto errors
+// are highlighted if standard error is a terminal.
+func (d *DiagnosticFlags) Setup() error {
+	format := TextDiagnostics
+	if d.json {
+		format = JSONDiagnostics
+	}
+	var color bool
+	switch d.color {
+	case "always":
+		color = true
+	case "never":
+		color = false
+	case "auto":
+		fileinfo, err := os.Stderr.Stat()
+		color = err == nil && fileinfo.Mode()&os.ModeCharDevice != 0 && os.Getenv("TERM") != "dumb"
+	default:
+		return fmt.Errorf("-color must be auto, always or never, not %s", d.color)
+	}
+	SetDiagnostics(os.Stderr, format, color)
+	return nil
+}
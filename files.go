@@ -0,0 +1,137 @@
+package jack
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Stdio is the file name that stands for standard input when reading and
+// for standard output when writing.
+const Stdio = "-"
+
+// Source is an input file, either named on the command line or found in a
+// directory named there.
+type Source struct {
+	Filename string // Stdio for standard input
+	Rel      string // path below the directory it was found in, for a file its base name
+}
+
+// ResolveAll returns the files of type filetype named by args, in order. A
+// file is taken as is, a directory is searched recursively and Stdio stands
+// for standard input.
+func ResolveAll(args []string, filetype string) (sources []Source, err error) {
+	for _, arg := range args {
+		if arg == Stdio {
+			sources = append(sources, Source{Filename: Stdio, Rel: Stdio})
+			continue
+		}
+		var fileinfo os.FileInfo
+		fileinfo, err = os.Stat(arg)
+		if err != nil {
+			return
+		}
+		if !fileinfo.IsDir() {
+			if !strings.HasSuffix(arg, filetype) {
+				err = fmt.Errorf("File %s of wrong type, must be of type %s", arg, filetype)
+				return
+			}
+			sources = append(sources, Source{Filename: arg, Rel: filepath.Base(arg)})
+			continue
+		}
+		// WalkDir visits the files in lexical order
+		err = filepath.WalkDir(arg, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() || !strings.HasSuffix(path, filetype) {
+				return nil
+			}
+			rel, err := filepath.Rel(arg, path)
+			if err != nil {
+				return err
+			}
+			sources = append(sources, Source{Filename: path, Rel: rel})
+			return nil
+		})
+		if err != nil {
+			return
+		}
+	}
+	if len(sources) == 0 {
+		err = fmt.Errorf("no %s files found in %s", filetype, strings.Join(args, " "))
+	}
+	return
+}
+
+// OutputFilename returns the file that the output of type filetype for src
+// goes to: beside src, or at the same relative path under outDir if that is
+// not empty. The output for standard input goes to standard output.
+func OutputFilename(src Source, outDir string, filetype string) string {
+	if src.Filename == Stdio {
+		return Stdio
+	}
+	if outDir == "" {
+		return trimFiletype(src.Filename) + filetype
+	}
+	return filepath.Join(outDir, trimFiletype(src.Rel)+filetype)
+}
+
+// ProgramFilename returns the file that the output of type filetype for the
+// whole program named by arg goes to. For a file that is the file with its
+// type replaced, for a directory a file named after the directory inside it,
+// and in both cases the file goes to outDir instead if that is not empty.
+func ProgramFilename(arg string, outDir string, filetype string) string {
+	if arg == Stdio {
+		return Stdio
+	}
+	base := trimFiletype(arg)
+	if fileinfo, err := os.Stat(arg); err == nil && fileinfo.IsDir() {
+		// the absolute path gives . and .. the name of the directory
+		name := filepath.Base(filepath.Clean(arg))
+		if abs, err := filepath.Abs(arg); err == nil {
+			name = filepath.Base(abs)
+		}
+		base = filepath.Join(arg, name)
+	}
+	if outDir != "" {
+		base = filepath.Join(outDir, filepath.Base(base))
+	}
+	return base + filetype
+}
+
+func trimFiletype(filename string) string {
+	return strings.TrimSuffix(filename, filepath.Ext(filename))
+}
+
+// Open opens filename for reading, Stdio for standard input.
+func Open(filename string) (io.ReadCloser, error) {
+	if filename == Stdio {
+		return io.NopCloser(os.Stdin), nil
+	}
+	return os.Open(filename)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// Create creates filename, and the directories it is in, for writing. Stdio
+// is standard output, which is left open by Close.
+func Create(filename string) (io.WriteCloser, error) {
+	if filename == Stdio {
+		return nopWriteCloser{os.Stdout}, nil
+	}
+	err := os.MkdirAll(filepath.Dir(filename), 0755)
+	if err != nil {
+		return nil, err
+	}
+	return os.Create(filename)
+}
@@ -0,0 +1,44 @@
+package jack
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProgramFilename(t *testing.T) {
+	project := filepath.Join(t.TempDir(), "proj")
+	for _, dir := range []string{"sub", "build"} {
+		err := os.MkdirAll(filepath.Join(project, dir), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		dir      string // working directory, relative to project
+		arg      string
+		outDir   string
+		filetype string
+		want     string
+	}{
+		{".", ".", "", ".asm", "proj.asm"},
+		{".", ".", "build", ".asm", filepath.Join("build", "proj.asm")},
+		{".", "./", "", ".hack", "proj.hack"},
+		{"sub", "..", "", ".hack", filepath.Join("..", "proj.hack")},
+		{"sub", "..", "build", ".hack", filepath.Join("build", "proj.hack")},
+		{".", "sub", "", ".asm", filepath.Join("sub", "sub.asm")},
+		{".", "sub", "build", ".asm", filepath.Join("build", "sub.asm")},
+		{".", "Main.vm", "", ".asm", "Main.asm"},
+		{".", "Main.vm", "build", ".asm", filepath.Join("build", "Main.asm")},
+		{".", Stdio, "build", ".asm", Stdio},
+	}
+	for _, test := range tests {
+		t.Run(test.dir+" "+test.arg+" "+test.outDir, func(t *testing.T) {
+			t.Chdir(filepath.Join(project, test.dir))
+			got := ProgramFilename(test.arg, test.outDir, test.filetype)
+			if got != test.want {
+				t.Errorf("ProgramFilename(%q, %q, %q) = %q, want %q", test.arg, test.outDir, test.filetype, got, test.want)
+			}
+		})
+	}
+}
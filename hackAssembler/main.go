@@ -1,16 +1,87 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"io"
+	"jack"
 	"jack/hackAssembler/parser"
 	"os"
 )
 
+func printErrorAndExit(err interface{}) {
+	if diagnostics, ok := err.(*jack.Diagnostics); ok {
+		err = diagnostics.Report()
+	}
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
+
 func main() {
-	if len(os.Args) != 2 {
-		fmt.Println("Usage: hackAssember <file>")
+	var output string
+	var outDir string
+	var writeMap bool
+	flag.StringVar(&output, "o", "", "output file, - for standard output (default <name>1.hack beside the input)")
+	flag.StringVar(&outDir, "out-dir", "", "write .hack files under this directory, mirroring the source tree")
+	flag.BoolVar(&writeMap, "map", false, "write a source map <hack file>.map from ROM addresses to assembly lines")
+	diagnosticFlags := jack.AddDiagnosticFlags(flag.CommandLine)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: hackAssembler [options] <asm file or directory | -> ...\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
 		os.Exit(1)
 	}
-	asmFile := os.Args[1]
-	parser.ParseFile(asmFile)
+	if output != "" && outDir != "" {
+		printErrorAndExit("-o and -out-dir cannot be used together")
+	}
+	err := diagnosticFlags.Setup()
+	if err != nil {
+		printErrorAndExit(err)
+	}
+	sources, err := jack.ResolveAll(flag.Args(), ".asm")
+	if err != nil {
+		printErrorAndExit(err)
+	}
+	if output != "" && len(sources) > 1 {
+		printErrorAndExit("-o needs a single input file")
+	}
+	for _, src := range sources {
+		hackFilename := output
+		if hackFilename == "" {
+			hackFilename = jack.OutputFilename(src, outDir, "1.hack")
+		}
+		var listing io.Writer = os.Stdout
+		if hackFilename == jack.Stdio {
+			listing = nil
+		}
+		sourceMap := assemble(src.Filename, hackFilename, listing)
+		if writeMap && hackFilename != jack.Stdio {
+			sourceMap.Generated = hackFilename
+			err = sourceMap.WriteFile()
+			if err != nil {
+				printErrorAndExit(err)
+			}
+		}
+	}
+}
+
+func assemble(asmFilename, hackFilename string, listing io.Writer) *jack.SourceMap {
+	r, err := jack.Open(asmFilename)
+	if err != nil {
+		printErrorAndExit(err)
+	}
+	defer r.Close()
+	w, err := jack.Create(hackFilename)
+	if err != nil {
+		printErrorAndExit(err)
+	}
+	defer w.Close()
+	sourceMap, err := parser.Assemble(r, asmFilename, w, listing)
+	if err != nil {
+		printErrorAndExit(err)
+	}
+	return sourceMap
 }
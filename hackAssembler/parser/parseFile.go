@@ -2,24 +2,32 @@ package parser
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"io"
-	"os"
-	"path/filepath"
+	"jack"
 	"strconv"
 	"strings"
 )
 
-var writer io.WriteCloser
+var writer io.Writer
+var listing io.Writer
+var sourceFilename string
+var sourceMap *jack.SourceMap
 var lineno int
 var instrno int
 
+// diagnostics collects the errors found in the assembly.
+var diagnostics *jack.Diagnostics
+
 type symbol struct {
 	name    string
 	address int
 }
 
-var symbols []symbol = []symbol{
+var symbols []symbol
+
+var predefinedSymbols = []symbol{
 	{name: "SCREEN", address: 16384},
 	{name: "KBD", address: 24576},
 	{name: "SP", address: 0},
@@ -54,45 +62,53 @@ func trimLine(line string) string {
 	return line
 }
 
-func forLinesInFile(filename string, parseLine func(line string) error) {
-	f, err := os.Open(filename)
-	if err != nil {
-		panic(err)
-	}
-	defer f.Close()
-	scanner := bufio.NewScanner(f)
+// forLinesInReader calls parseLine for every line read from r and reports
+// the errors it returns, so that all errors of a file are found at once.
+func forLinesInReader(r io.Reader, parseLine func(line string) error) {
+	lineno = 0
+	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
 		lineno++
-		line := scanner.Text()
-		line = trimLine(line)
-		err = parseLine(line)
+		origLine := scanner.Text()
+		line := trimLine(origLine)
+		err := parseLine(line)
 		if err != nil {
-			fmt.Printf("line %d, %v", lineno, err)
-			panic(err)
+			diagnostics.Errorf(jack.LineSpan(sourceFilename, lineno, origLine), "%v", err)
 		}
 	}
 }
 
-func ParseFile(filename string) {
-	dir := filepath.Dir(filename)
-	inputBase := filepath.Base(filename)
-	asmExt := filepath.Ext(filename)
-	if asmExt != ".asm" {
-		fmt.Println("file must have 'asm' extenstion")
-		return
-	}
-	hackFile := filepath.Join(dir, inputBase[0:len(inputBase)-4]+"1.hack")
-	var err error
-	writer, err = os.Create(hackFile)
+// Assemble translates the assembly read from r, which is called name in the
+// returned map and in error messages, into Hack machine code written to w,
+// and lists every instruction with its address and code to lst unless that
+// is nil. The returned map leads from ROM addresses to the lines of the
+// assembly. If there are errors Assemble fails with the *jack.Diagnostics
+// of them all, and the code written is incomplete.
+func Assemble(r io.Reader, name string, w io.Writer, lst io.Writer) (*jack.SourceMap, error) {
+	source, err := io.ReadAll(r)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+	jack.SetSourceText(name, source)
+	writer = w
+	listing = lst
+	sourceFilename = name
+	diagnostics = &jack.Diagnostics{}
+	symbols = append([]symbol(nil), predefinedSymbols...)
+	instrno = 0
+	forLinesInReader(bytes.NewReader(source), firstPass)
+	if diagnostics.Errors() > 0 {
+		return nil, diagnostics
 	}
-	defer writer.Close()
-	forLinesInFile(filename, firstPass)
 	resolveVariables()
 	printSymbolTable(false)
 	instrno = 0
-	forLinesInFile(filename, secondPass)
+	sourceMap = &jack.SourceMap{}
+	forLinesInReader(bytes.NewReader(source), secondPass)
+	if diagnostics.Errors() > 0 {
+		return nil, diagnostics
+	}
+	return sourceMap, nil
 }
 
 func resolveVariables() {
@@ -195,7 +211,7 @@ func parseValue(value string) int {
 	return -1
 }
 
-func parseCinstruction(a string) Cinstruction {
+func parseCinstruction(a string) (Cinstruction, error) {
 	// dest=value;jmp
 	instr := Cinstruction{}
 	equalsIdx := strings.Index(a, "=")
@@ -210,7 +226,7 @@ func parseCinstruction(a string) Cinstruction {
 			} else if ch == "M" {
 				instr.dest |= 1
 			} else {
-				fmt.Printf("Syntax error on line %d\n", lineno)
+				return instr, fmt.Errorf("invalid destination %s", dest)
 			}
 		}
 	}
@@ -222,8 +238,14 @@ func parseCinstruction(a string) Cinstruction {
 		value = value[0:semiIndex]
 	}
 	instr.value = parseValue(value)
+	if instr.value < 0 {
+		return instr, fmt.Errorf("invalid computation %s", strings.TrimSpace(value))
+	}
 	instr.jmp = parseJump(jump)
-	return instr
+	if instr.jmp < 0 {
+		return instr, fmt.Errorf("invalid jump %s", jump)
+	}
+	return instr, nil
 }
 
 func parseJump(jump string) int {
@@ -242,8 +264,10 @@ func parseJump(jump string) int {
 		return 1
 	case "JNE":
 		return 5
+	case "":
+		return 0
 	}
-	return 0
+	return -1
 }
 
 func parseAinstruction(a string) int {
@@ -291,8 +315,14 @@ func firstPass(line string) error {
 		label := line[1 : len(line)-1]
 		setSymbol(label, instrno)
 	} else if iType == "A" {
+		if len(line) == 1 {
+			return fmt.Errorf("expected address or symbol after @")
+		}
 		firstChar := line[1]
 		if firstChar >= '0' && firstChar <= '9' {
+			if addr, err := strconv.Atoi(line[1:]); err != nil || addr > 32767 {
+				return fmt.Errorf("invalid address %s", line[1:])
+			}
 		} else {
 			name := line[1:]
 			setSymbol(name, -1)
@@ -314,7 +344,11 @@ func secondPass(line string) error {
 	pc := instrno
 	var outstr string
 	if iType == "C" {
-		cinstr = parseCinstruction(line)
+		var err error
+		cinstr, err = parseCinstruction(line)
+		if err != nil {
+			return err
+		}
 		outstr = outputCinstruction(cinstr)
 		instrno++
 	} else if iType == "A" {
@@ -322,9 +356,12 @@ func secondPass(line string) error {
 		outstr = outputAinstruction(ainstr)
 		instrno++
 	}
-	fmt.Printf("pc:%d:%s:%s\n", pc, line, outstr)
+	if listing != nil {
+		fmt.Fprintf(listing, "pc:%d:%s:%s\n", pc, line, outstr)
+	}
 	if len(outstr) > 0 {
 		fmt.Fprintf(writer, "%s\n", outstr)
+		sourceMap.Add(pc, jack.Position{Filename: sourceFilename, Line: lineno})
 	}
 	return nil
 }
@@ -0,0 +1,213 @@
+package cpu
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	Screen   = 16384
+	Keyboard = 24576
+	RAMSize  = 32768
+	ROMSize  = 32768
+)
+
+// Machine is a Hack computer: CPU, instruction memory and data memory.
+type Machine struct {
+	ROM []uint16
+	RAM [RAMSize]int16
+	A   int16
+	D   int16
+	PC  int
+
+	// loop detection, see checkLoop
+	snapshot      [RAMSize]int16
+	snapshotA     int16
+	snapshotD     int16
+	snapshotPC    int
+	differences   int
+	sinceSnapshot int
+	looping       bool
+}
+
+// snapshotInterval is the number of steps after which checkLoop takes a new
+// snapshot of the machine.
+const snapshotInterval = 1 << 16
+
+// Load reads a program in the text format written by hackAssembler: one
+// instruction per line as 16 binary digits.
+func Load(r io.Reader) ([]uint16, error) {
+	var rom []uint16
+	scanner := bufio.NewScanner(r)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if len(line) != 16 {
+			return nil, fmt.Errorf("line %d: instruction must have 16 bits", lineno)
+		}
+		instr, err := strconv.ParseUint(line, 2, 16)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineno, err)
+		}
+		rom = append(rom, uint16(instr))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(rom) > ROMSize {
+		return nil, fmt.Errorf("program has %d instructions, the ROM holds %d", len(rom), ROMSize)
+	}
+	return rom, nil
+}
+
+func New(rom []uint16) *Machine {
+	m := &Machine{ROM: rom}
+	m.Reset()
+	return m
+}
+
+// Reset clears the registers and data memory and restarts the program.
+func (m *Machine) Reset() {
+	m.RAM = [RAMSize]int16{}
+	m.A = 0
+	m.D = 0
+	m.PC = 0
+	m.looping = false
+	m.takeSnapshot()
+}
+
+// Halted reports whether the machine has stopped: the program counter left
+// the program, or the machine is in a loop that can never end because it
+// came back to a state it was in before.
+func (m *Machine) Halted() bool {
+	return m.PC < 0 || m.PC >= len(m.ROM) || m.looping
+}
+
+// Run executes at most maxSteps instructions, fewer if the machine halts,
+// and returns the number executed.
+func (m *Machine) Run(maxSteps int) int {
+	steps := 0
+	for steps < maxSteps && !m.Halted() {
+		m.Step()
+		steps++
+	}
+	return steps
+}
+
+func (m *Machine) address() int {
+	return int(uint16(m.A) & (RAMSize - 1))
+}
+
+// Step executes the instruction at PC.
+func (m *Machine) Step() {
+	instr := m.ROM[m.PC]
+	if instr&0x8000 == 0 {
+		m.A = int16(instr)
+		m.PC++
+		m.checkLoop()
+		return
+	}
+	x := m.D
+	y := m.A
+	if instr&0x1000 != 0 {
+		y = m.RAM[m.address()]
+	}
+	out := alu(x, y, instr>>6)
+	if instr&0x08 != 0 {
+		m.write(m.address(), out)
+	}
+	if instr&0x20 != 0 {
+		m.A = out
+	}
+	if instr&0x10 != 0 {
+		m.D = out
+	}
+	jump := false
+	switch {
+	case out < 0:
+		jump = instr&0x04 != 0
+	case out == 0:
+		jump = instr&0x02 != 0
+	default:
+		jump = instr&0x01 != 0
+	}
+	if jump {
+		m.PC = int(uint16(m.A))
+	} else {
+		m.PC++
+	}
+	m.checkLoop()
+}
+
+// alu computes the comp part of a C-instruction, whose six control bits
+// zx nx zy ny f no are the low bits of c.
+func alu(x, y int16, c uint16) int16 {
+	if c&0x20 != 0 {
+		x = 0
+	}
+	if c&0x10 != 0 {
+		x = ^x
+	}
+	if c&0x08 != 0 {
+		y = 0
+	}
+	if c&0x04 != 0 {
+		y = ^y
+	}
+	var out int16
+	if c&0x02 != 0 {
+		out = x + y
+	} else {
+		out = x & y
+	}
+	if c&0x01 != 0 {
+		out = ^out
+	}
+	return out
+}
+
+func (m *Machine) write(address int, value int16) {
+	old := m.RAM[address]
+	if old == value {
+		return
+	}
+	before := m.snapshot[address]
+	if old == before {
+		m.differences++
+	} else if value == before {
+		m.differences--
+	}
+	m.RAM[address] = value
+}
+
+// checkLoop notices when the machine gets back into the state of its last
+// snapshot. Nothing but the keyboard can change the course of a Hack
+// program, so without input it will then repeat itself for ever. The number
+// of RAM words that differ from the snapshot is kept up to date by write,
+// which makes the check cheap enough for every step.
+func (m *Machine) checkLoop() {
+	if m.PC == m.snapshotPC && m.A == m.snapshotA && m.D == m.snapshotD && m.differences == 0 {
+		m.looping = true
+		return
+	}
+	m.sinceSnapshot++
+	if m.sinceSnapshot >= snapshotInterval {
+		m.takeSnapshot()
+	}
+}
+
+func (m *Machine) takeSnapshot() {
+	m.snapshot = m.RAM
+	m.snapshotA = m.A
+	m.snapshotD = m.D
+	m.snapshotPC = m.PC
+	m.differences = 0
+	m.sinceSnapshot = 0
+}
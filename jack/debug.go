@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"jack"
+	translator "jack/VMtranslator/parser"
+	"jack/hackEmulator/cpu"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const debugHelp = `Commands:
+  break [function | file:line]  set a breakpoint, or list them without argument
+  delete [n]                    delete breakpoint n, or all of them
+  step [n]                      execute n commands, default 1
+  next                          execute one command, stepping over calls
+  continue                      run until a breakpoint or the program halts
+  restart                       start the program again, keeping the breakpoints
+  where                         show the next command
+  stack                         print the working stack of the current function
+  print <segment> [count]       print local, argument, this, that or static of
+                                the selected frame; count words of this or that
+  backtrace                     print the call stack
+  frame [n]                     select frame n of the call stack, 0 is the innermost
+  quit                          leave the debugger
+An empty line repeats the last command. All commands but stack may be
+abbreviated to their first letter, backtrace also to bt.
+`
+
+// debugger is the state of an interactive session of debug.
+type debugger struct {
+	vm          *translator.VM
+	breakpoints []int // commands to stop at
+	frame       int   // frame selected for print
+	maxSteps    int   // commands continue and next run at most
+}
+
+// debug runs VM code on a VM interpreter under the control of commands read
+// from standard input.
+func debug(args []string) {
+	flags := flag.NewFlagSet("debug", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: jack debug [options] <vm file or directory> ...\n")
+		flags.PrintDefaults()
+		fmt.Fprintf(flags.Output(), "\n%s", debugHelp)
+	}
+	t := addTranslateFlags(flags)
+	diagnosticFlags := jack.AddDiagnosticFlags(flags)
+	d := &debugger{}
+	flags.IntVar(&d.maxSteps, "steps", 100000000, "stop continue and next after this many commands")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(1)
+	}
+	err := diagnosticFlags.Setup()
+	if err != nil {
+		printErrorAndExit(err)
+	}
+	var filenames []string
+	for _, src := range resolve(flags, ".vm") {
+		if src.Filename == jack.Stdio {
+			printErrorAndExit("the debugger reads its commands from standard input")
+		}
+		filenames = append(filenames, src.Filename)
+	}
+	vm, err := translator.LoadVM(filenames, t.setup())
+	if err != nil {
+		printErrorAndExit(err)
+	}
+	translator.Diagnostics().Report()
+	d.vm = vm
+	d.where()
+	scanner := bufio.NewScanner(os.Stdin)
+	var last []string
+	for {
+		fmt.Print("(debug) ")
+		if !scanner.Scan() {
+			fmt.Println()
+			return
+		}
+		words := strings.Fields(scanner.Text())
+		if len(words) == 0 {
+			words = last
+		}
+		if len(words) == 0 {
+			continue
+		}
+		last = words
+		if words[0] == "quit" || words[0] == "q" {
+			return
+		}
+		err := d.execute(words[0], words[1:])
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+}
+
+func (d *debugger) execute(command string, args []string) error {
+	switch command {
+	case "break", "b":
+		if len(args) == 0 {
+			d.listBreakpoints()
+			return nil
+		}
+		return d.setBreakpoint(args[0])
+	case "delete", "d":
+		return d.deleteBreakpoint(args)
+	case "step", "s":
+		n := 1
+		if len(args) > 0 {
+			var err error
+			n, err = strconv.Atoi(args[0])
+			if err != nil || n < 1 {
+				return fmt.Errorf("bad count %s", args[0])
+			}
+		}
+		for i := 0; i < n && !d.vm.Halted(); i++ {
+			d.vm.Step()
+		}
+		d.stopped()
+	case "next", "n":
+		depth := d.vm.Depth
+		d.vm.Step()
+		d.run(func() bool { return d.vm.Depth <= depth })
+	case "continue", "c":
+		d.vm.Step()
+		d.run(func() bool { return false })
+	case "restart", "r":
+		d.vm.Reset()
+		d.stopped()
+	case "where", "w":
+		d.where()
+	case "stack":
+		fmt.Println(formatWords(d.vm.WorkingStack()))
+	case "print", "p":
+		if len(args) == 0 {
+			return fmt.Errorf("print needs a segment")
+		}
+		return d.printSegment(args[0], args[1:])
+	case "backtrace", "bt":
+		for i, f := range d.vm.Frames() {
+			marker := " "
+			if i == d.frame {
+				marker = "*"
+			}
+			fmt.Printf("%s#%d %s at %v (LCL=%d ARG=%d THIS=%d THAT=%d)\n", marker, i, f.Function, d.vm.Position(f.PC), f.LCL, f.ARG, f.THIS, f.THAT)
+		}
+	case "frame", "f":
+		if len(args) == 0 {
+			fmt.Printf("frame %d\n", d.frame)
+			return nil
+		}
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 0 || n >= len(d.vm.Frames()) {
+			return fmt.Errorf("no frame %s", args[0])
+		}
+		d.frame = n
+	case "help", "h":
+		fmt.Print(debugHelp)
+	default:
+		return fmt.Errorf("unknown command %s, try help", command)
+	}
+	return nil
+}
+
+// run steps until done says so, a breakpoint is reached, the program halts
+// or maxSteps commands have run.
+func (d *debugger) run(done func() bool) {
+	for steps := 1; !d.vm.Halted() && !done() && !d.atBreakpoint(); steps++ {
+		if steps >= d.maxSteps {
+			fmt.Printf("stopped after %d commands\n", steps)
+			break
+		}
+		d.vm.Step()
+	}
+	d.stopped()
+}
+
+func (d *debugger) atBreakpoint() bool {
+	for _, pc := range d.breakpoints {
+		if pc == d.vm.PC {
+			return true
+		}
+	}
+	return false
+}
+
+// stopped selects the innermost frame and shows where the program stopped.
+func (d *debugger) stopped() {
+	d.frame = 0
+	for i, pc := range d.breakpoints {
+		if pc == d.vm.PC && !d.vm.Halted() {
+			fmt.Printf("breakpoint %d\n", i+1)
+		}
+	}
+	d.where()
+}
+
+func (d *debugger) where() {
+	if d.vm.StackOverflow {
+		fmt.Println("stack overflow")
+	}
+	if d.vm.PC >= d.vm.NumCommands() {
+		fmt.Println("halted at the end of the program")
+		return
+	}
+	if d.vm.Halted() {
+		fmt.Print("halted at ")
+	}
+	fmt.Printf("%v %s: %s\n", d.vm.Position(d.vm.PC), d.vm.Function(d.vm.PC), d.vm.Source(d.vm.PC))
+}
+
+// setBreakpoint sets a breakpoint at the 'function' command of a function,
+// or at the first command at or after file:line.
+func (d *debugger) setBreakpoint(location string) error {
+	pc, ok := d.vm.FunctionPC(location)
+	if !ok {
+		position, err := jack.ParsePosition(location)
+		if err != nil {
+			return fmt.Errorf("%s is neither a function nor of the form file:line", location)
+		}
+		pc, ok = d.vm.LinePC(position.Filename, position.Line)
+		if !ok {
+			return fmt.Errorf("no command at or after %s", location)
+		}
+	}
+	d.breakpoints = append(d.breakpoints, pc)
+	fmt.Printf("breakpoint %d at %v %s: %s\n", len(d.breakpoints), d.vm.Position(pc), d.vm.Function(pc), d.vm.Source(pc))
+	return nil
+}
+
+func (d *debugger) listBreakpoints() {
+	if len(d.breakpoints) == 0 {
+		fmt.Println("no breakpoints")
+	}
+	for i, pc := range d.breakpoints {
+		fmt.Printf("%d: %v %s: %s\n", i+1, d.vm.Position(pc), d.vm.Function(pc), d.vm.Source(pc))
+	}
+}
+
+func (d *debugger) deleteBreakpoint(args []string) error {
+	if len(args) == 0 {
+		d.breakpoints = nil
+		return nil
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 1 || n > len(d.breakpoints) {
+		return fmt.Errorf("no breakpoint %s", args[0])
+	}
+	d.breakpoints = append(d.breakpoints[0:n-1], d.breakpoints[n:]...)
+	return nil
+}
+
+// printSegment prints a segment of the selected frame with the RAM address
+// of every word.
+func (d *debugger) printSegment(segment string, args []string) error {
+	frames := d.vm.Frames()
+	if d.frame >= len(frames) {
+		return fmt.Errorf("no frame %d", d.frame)
+	}
+	f := frames[d.frame]
+	count := 1
+	if len(args) > 0 {
+		var err error
+		count, err = strconv.Atoi(args[0])
+		if err != nil || count < 0 {
+			return fmt.Errorf("bad count %s", args[0])
+		}
+	}
+	var base int16
+	switch segment {
+	case "local":
+		base = f.LCL
+		count = d.vm.NumLocals(f.Function)
+	case "argument":
+		base = f.ARG
+		count = f.NumArgs()
+	case "this":
+		base = f.THIS
+	case "that":
+		base = f.THAT
+	case "static":
+		for _, s := range d.vm.Statics(d.vm.Module(f.PC)) {
+			fmt.Printf("static %d = %d (RAM[%d])\n", s.Index, d.vm.RAM[s.Address], s.Address)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown segment %s", segment)
+	}
+	for i := 0; i < count; i++ {
+		address := int(uint16(base+int16(i))) & (cpu.RAMSize - 1)
+		fmt.Printf("%s %d = %d (RAM[%d])\n", segment, i, d.vm.RAM[address], address)
+	}
+	return nil
+}
+
+func formatWords(words []int16) string {
+	var parts []string
+	for _, w := range words {
+		parts = append(parts, strconv.Itoa(int(w)))
+	}
+	return "[" + strings.Join(parts, " ") + "]"
+}
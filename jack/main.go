@@ -0,0 +1,586 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"jack"
+	compiler "jack/JackC/parser"
+	"jack/VMtranslator/oslib"
+	translator "jack/VMtranslator/parser"
+	assembler "jack/hackAssembler/parser"
+	"jack/hackEmulator/cpu"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+func printErrorAndExit(err interface{}) {
+	if diagnostics, ok := err.(*jack.Diagnostics); ok {
+		err = diagnostics.Report()
+	}
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: jack <command> [options] <inputs>
+
+Commands:
+  build      compile Jack sources into a Hack binary
+  run        build a program, or load a .hack file, and run it on a Hack emulator
+  compile    compile Jack sources into VM code
+  translate  translate VM code into Hack assembly
+  assemble   assemble Hack assembly into a Hack binary
+  map        look up ROM addresses or source lines in source maps
+  debug      step through VM code with breakpoints and frame inspection
+
+Inputs are files or directories, which are searched recursively, or - for
+standard input. Run jack <command> -h for the options of a command.
+`)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(1)
+	}
+	args := os.Args[2:]
+	switch os.Args[1] {
+	case "build":
+		build(args)
+	case "run":
+		run(args)
+	case "compile":
+		compile(args)
+	case "translate":
+		translate(args)
+	case "assemble":
+		assemble(args)
+	case "map":
+		lookupMap(args)
+	case "debug":
+		debug(args)
+	case "help", "-h", "-help", "--help":
+		usage()
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %s\n", os.Args[1])
+		usage()
+		os.Exit(1)
+	}
+}
+
+// outputFlags are the options every command has for where its output goes
+// and how errors are reported.
+type outputFlags struct {
+	output      string
+	outDir      string
+	diagnostics *jack.DiagnosticFlags
+}
+
+func newFlagSet(name string, inputs string) (*flag.FlagSet, *outputFlags) {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: jack %s [options] <%s | -> ...\n", name, inputs)
+		flags.PrintDefaults()
+	}
+	out := &outputFlags{}
+	flags.StringVar(&out.output, "o", "", "output file, - for standard output")
+	flags.StringVar(&out.outDir, "out-dir", "", "write output files under this directory, mirroring the source tree")
+	out.diagnostics = jack.AddDiagnosticFlags(flags)
+	return flags, out
+}
+
+func parseFlags(flags *flag.FlagSet, out *outputFlags, args []string) {
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(1)
+	}
+	if out.output != "" && out.outDir != "" {
+		printErrorAndExit("-o and -out-dir cannot be used together")
+	}
+	err := out.diagnostics.Setup()
+	if err != nil {
+		printErrorAndExit(err)
+	}
+}
+
+// programFilename returns the file the output of type filetype for the whole
+// program goes to.
+func (out *outputFlags) programFilename(flags *flag.FlagSet, filetype string) string {
+	if out.output != "" {
+		return out.output
+	}
+	return jack.ProgramFilename(flags.Arg(0), out.outDir, filetype)
+}
+
+// translateFlags are the options of the VM translation stage, the same as
+// those of VMtranslator.
+type translateFlags struct {
+	config   translator.Config
+	noBoot   bool
+	osLibDir string
+	noOsLib  bool
+}
+
+func addTranslateFlags(flags *flag.FlagSet) *translateFlags {
+	t := &translateFlags{config: translator.DefaultConfig()}
+	config := &t.config
+	flags.BoolVar(&t.noBoot, "noboot", false, "do not add boot code")
+	flags.IntVar(&config.StackPointer, "sp", config.StackPointer, "initial stack pointer")
+	flags.StringVar(&config.Entry, "entry", config.Entry, "function called by the boot code, empty to start at the first command")
+	flags.IntVar(&config.Local, "lcl", config.Local, "initial value of LCL, -1 to leave unset")
+	flags.IntVar(&config.Argument, "arg", config.Argument, "initial value of ARG, -1 to leave unset")
+	flags.IntVar(&config.This, "this", config.This, "initial value of THIS, -1 to leave unset")
+	flags.IntVar(&config.That, "that", config.That, "initial value of THAT, -1 to leave unset")
+	flags.IntVar(&config.StackLimit, "stacklimit", config.StackLimit, "check on function entry that SP stays at or below this address, 0 for no checks")
+	flags.BoolVar(&config.TailCalls, "tco", false, "optimise calls whose result is returned at once into frame-reusing jumps")
+	flags.BoolVar(&config.InlineMath, "inlinemath", false, "replace calls to Math.multiply and Math.divide with inline assembly")
+	flags.StringVar(&t.osLibDir, "oslib", "", "directory of OS .vm files to link instead of the built-in library")
+	flags.BoolVar(&t.noOsLib, "nooslib", false, "do not link any OS library")
+	return t
+}
+
+// setup sets the library to link and returns the translator configuration.
+func (t *translateFlags) setup() translator.Config {
+	if t.noOsLib {
+		translator.SetLibrary("", nil)
+	} else if t.osLibDir != "" {
+		translator.SetLibrary(t.osLibDir, os.DirFS(t.osLibDir))
+	} else {
+		translator.SetLibrary("oslib", oslib.FS)
+	}
+	t.config.Boot = !t.noBoot
+	return t.config
+}
+
+// compileFlags are the options of the compilation stage, the same as those
+// of JackC.
+type compileFlags struct {
+	typeCheck  bool
+	strict     bool
+	precedence bool
+}
+
+func addCompileFlags(flags *flag.FlagSet) *compileFlags {
+	c := &compileFlags{}
+	flags.BoolVar(&c.typeCheck, "typecheck", false, "check types, letting int, char and objects stand for each other")
+	flags.BoolVar(&c.strict, "strict", false, "check types without letting int, char and objects stand for each other")
+	flags.BoolVar(&c.precedence, "precedence", false, "apply * and / before + and -, those before <, > and =, and those before & and |, as a //jack:precedence comment before a class does")
+	return c
+}
+
+// setup returns a compiler that checks types as strictly as asked for and
+// parses with operator precedence if asked to.
+func (c *compileFlags) setup() *compiler.Compiler {
+	jackc := compiler.NewCompiler()
+	if c.strict {
+		jackc.SetTypeCheck(compiler.Strict)
+	} else if c.typeCheck {
+		jackc.SetTypeCheck(compiler.Permissive)
+	}
+	jackc.SetPrecedence(c.precedence)
+	return jackc
+}
+
+// buildFlags are the options of build and run.
+type buildFlags struct {
+	*compileFlags
+	*translateFlags
+	keepVM   bool
+	keepAsm  bool
+	writeMap bool
+}
+
+func addBuildFlags(flags *flag.FlagSet) *buildFlags {
+	b := &buildFlags{compileFlags: addCompileFlags(flags), translateFlags: addTranslateFlags(flags)}
+	flags.BoolVar(&b.keepVM, "keep-vm", false, "write the VM files beside the sources, or under -out-dir")
+	flags.BoolVar(&b.keepAsm, "keep-asm", false, "write the assembly beside the binary")
+	flags.BoolVar(&b.writeMap, "map", false, "write a source map <hack file>.map from ROM addresses to assembly, VM and Jack lines")
+	return b
+}
+
+func resolve(flags *flag.FlagSet, filetype string) []jack.Source {
+	sources, err := jack.ResolveAll(flags.Args(), filetype)
+	if err != nil {
+		printErrorAndExit(err)
+	}
+	return sources
+}
+
+func writeFile(filename string, data []byte) {
+	wrt, err := jack.Create(filename)
+	if err != nil {
+		printErrorAndExit(err)
+	}
+	defer wrt.Close()
+	_, err = wrt.Write(data)
+	if err != nil {
+		printErrorAndExit(err)
+	}
+}
+
+func writeMap(sourceMap *jack.SourceMap) {
+	if sourceMap.Generated == jack.Stdio {
+		return
+	}
+	err := sourceMap.WriteFile()
+	if err != nil {
+		printErrorAndExit(err)
+	}
+}
+
+// compileSources compiles the Jack classes of a program in parallel and
+// returns the VM code of each and the map from its VM lines to Jack lines.
+func compileSources(jackc *compiler.Compiler, sources []jack.Source) ([][]byte, []*jack.SourceMap) {
+	classes, err := jackc.ParseSources(sources)
+	if err != nil {
+		printErrorAndExit(err)
+	}
+	err = jackc.Check(classes)
+	if err != nil {
+		printErrorAndExit(err)
+	}
+	jackc.Diagnostics().Report()
+	return jackc.OutputClasses(classes)
+}
+
+// translateFiles translates VM files, read from disk unless they were given
+// to translator.SetSource, and returns the assembly and the map from its
+// lines to VM commands.
+func translateFiles(filenames []string, config translator.Config) ([]byte, *jack.SourceMap) {
+	var buf bytes.Buffer
+	translator.SetWriter(&buf)
+	sourceMap, err := translator.ParseFiles(filenames, config)
+	if err != nil {
+		printErrorAndExit(err)
+	}
+	translator.Diagnostics().Report()
+	return buf.Bytes(), sourceMap
+}
+
+// assembleCode assembles the assembly of the file asmFilename and returns the
+// binary and the map from ROM addresses to assembly lines.
+func assembleCode(asm []byte, asmFilename string) ([]byte, *jack.SourceMap) {
+	var buf bytes.Buffer
+	sourceMap, err := assembler.Assemble(bytes.NewReader(asm), asmFilename, &buf, nil)
+	if err != nil {
+		printErrorAndExit(err)
+	}
+	return buf.Bytes(), sourceMap
+}
+
+// buildProgram takes the Jack sources named on the command line through all
+// three stages in memory and returns the binary, with a map that leads from
+// every ROM address through the assembly and VM code to a Jack line. The
+// intermediate files are only written if asked for.
+func buildProgram(flags *flag.FlagSet, out *outputFlags, b *buildFlags, hackFilename string) ([]byte, *jack.SourceMap) {
+	jackc := b.compileFlags.setup()
+	config := b.translateFlags.setup()
+	var vmFilenames []string
+	var maps []*jack.SourceMap
+	sources := resolve(flags, ".jack")
+	codes, vmMaps := compileSources(jackc, sources)
+	for i, src := range sources {
+		code, vmMap := codes[i], vmMaps[i]
+		vmFilename := jack.OutputFilename(src, out.outDir, ".vm")
+		if b.keepVM && vmFilename != jack.Stdio {
+			writeFile(vmFilename, code)
+		}
+		translator.SetSource(vmFilename, code)
+		vmFilenames = append(vmFilenames, vmFilename)
+		vmMap.Generated = vmFilename
+		maps = append(maps, vmMap)
+	}
+	asm, asmMap := translateFiles(vmFilenames, config)
+	asmFilename := jack.ProgramFilename(flags.Arg(0), out.outDir, ".asm")
+	if hackFilename != "" && hackFilename != jack.Stdio {
+		asmFilename = strings.TrimSuffix(hackFilename, filepath.Ext(hackFilename)) + ".asm"
+	}
+	if b.keepAsm {
+		writeFile(asmFilename, asm)
+	}
+	asmMap.Generated = asmFilename
+	maps = append(maps, asmMap)
+	hack, hackMap := assembleCode(asm, asmFilename)
+	hackMap.Generated = hackFilename
+	hackMap.Compose(maps)
+	return hack, hackMap
+}
+
+func build(args []string) {
+	flags, out := newFlagSet("build", "jack file or directory")
+	b := addBuildFlags(flags)
+	parseFlags(flags, out, args)
+	hackFilename := out.programFilename(flags, ".hack")
+	hack, sourceMap := buildProgram(flags, out, b, hackFilename)
+	writeFile(hackFilename, hack)
+	if b.writeMap {
+		writeMap(sourceMap)
+	}
+}
+
+func compile(args []string) {
+	flags, out := newFlagSet("compile", "jack file or directory")
+	c := addCompileFlags(flags)
+	writeMaps := flags.Bool("map", false, "write a source map <vm file>.map from VM lines to Jack lines")
+	parseFlags(flags, out, args)
+	jackc := c.setup()
+	sources := resolve(flags, ".jack")
+	if out.output != "" {
+		// the classes are written one after the other
+		var all []byte
+		sourceMap := &jack.SourceMap{Generated: out.output}
+		codes, classMaps := compileSources(jackc, sources)
+		for i, code := range codes {
+			classMap := classMaps[i]
+			sourceMap.Append(classMap, bytes.Count(all, []byte("\n")))
+			all = append(all, code...)
+		}
+		writeFile(out.output, all)
+		if *writeMaps {
+			writeMap(sourceMap)
+		}
+		return
+	}
+	codes, sourceMaps := compileSources(jackc, sources)
+	for i, src := range sources {
+		code, sourceMap := codes[i], sourceMaps[i]
+		sourceMap.Generated = jack.OutputFilename(src, out.outDir, ".vm")
+		writeFile(sourceMap.Generated, code)
+		if *writeMaps {
+			writeMap(sourceMap)
+		}
+	}
+}
+
+func translate(args []string) {
+	flags, out := newFlagSet("translate", "vm file or directory")
+	t := addTranslateFlags(flags)
+	writeMaps := flags.Bool("map", false, "write a source map <asm file>.map from assembly lines to VM commands")
+	parseFlags(flags, out, args)
+	config := t.setup()
+	var filenames []string
+	for _, src := range resolve(flags, ".vm") {
+		filenames = append(filenames, src.Filename)
+	}
+	asm, sourceMap := translateFiles(filenames, config)
+	sourceMap.Generated = out.programFilename(flags, ".asm")
+	writeFile(sourceMap.Generated, asm)
+	if *writeMaps {
+		writeMap(sourceMap)
+	}
+}
+
+func assemble(args []string) {
+	flags, out := newFlagSet("assemble", "asm file or directory")
+	writeMaps := flags.Bool("map", false, "write a source map <hack file>.map from ROM addresses to assembly lines")
+	parseFlags(flags, out, args)
+	sources := resolve(flags, ".asm")
+	if out.output != "" && len(sources) > 1 {
+		printErrorAndExit("-o needs a single input file")
+	}
+	for _, src := range sources {
+		asm := readFile(src.Filename)
+		hackFilename := out.output
+		if hackFilename == "" {
+			hackFilename = jack.OutputFilename(src, out.outDir, ".hack")
+		}
+		hack, sourceMap := assembleCode(asm, src.Filename)
+		sourceMap.Generated = hackFilename
+		writeFile(hackFilename, hack)
+		if *writeMaps {
+			writeMap(sourceMap)
+		}
+	}
+}
+
+func run(args []string) {
+	flags, out := newFlagSet("run", "hack file, or jack file or directory")
+	b := addBuildFlags(flags)
+	var steps int
+	var ramRanges string
+	var screenFilename string
+	flags.IntVar(&steps, "steps", 100000000, "stop after this many instructions")
+	flags.StringVar(&ramRanges, "ram", "", "print these RAM words when the program stops, as lo-hi ranges separated by commas")
+	flags.StringVar(&screenFilename, "screen", "", "write the screen to this file as a PBM image when the program stops")
+	parseFlags(flags, out, args)
+	var hack []byte
+	var sourceMap *jack.SourceMap
+	if flags.NArg() == 1 && strings.HasSuffix(flags.Arg(0), ".hack") {
+		hack = readFile(flags.Arg(0))
+		// use the map written by build -map if there is one
+		if f, err := os.Open(flags.Arg(0) + ".map"); err == nil {
+			sourceMap, _ = jack.ReadSourceMap(f)
+			f.Close()
+		}
+	} else {
+		hack, sourceMap = buildProgram(flags, out, b, out.output)
+		if out.output != "" {
+			writeFile(out.output, hack)
+			if b.writeMap {
+				writeMap(sourceMap)
+			}
+		}
+	}
+	rom, err := cpu.Load(bytes.NewReader(hack))
+	if err != nil {
+		printErrorAndExit(err)
+	}
+	m := cpu.New(rom)
+	n := m.Run(steps)
+	if m.Halted() {
+		fmt.Printf("halted after %d steps at ROM address %d\n", n, m.PC)
+	} else {
+		fmt.Printf("stopped after %d steps at ROM address %d\n", n, m.PC)
+	}
+	if sourceMap != nil {
+		if sources := sourceMap.Lookup(m.PC); sources != nil {
+			fmt.Printf("  from %s\n", formatSources(sources))
+		}
+	}
+	if ramRanges != "" {
+		err = printRAM(m, ramRanges)
+		if err != nil {
+			printErrorAndExit(err)
+		}
+	}
+	if screenFilename != "" {
+		writeFile(screenFilename, screenImage(m))
+	}
+}
+
+func printRAM(m *cpu.Machine, ranges string) error {
+	for _, r := range strings.Split(ranges, ",") {
+		loString, hiString := r, r
+		if dashIdx := strings.Index(r, "-"); dashIdx >= 0 {
+			loString, hiString = r[0:dashIdx], r[dashIdx+1:]
+		}
+		lo, err := strconv.Atoi(loString)
+		if err != nil {
+			return fmt.Errorf("bad RAM range %s", r)
+		}
+		hi, err := strconv.Atoi(hiString)
+		if err != nil || lo > hi || hi >= cpu.RAMSize {
+			return fmt.Errorf("bad RAM range %s", r)
+		}
+		for address := lo; address <= hi; address++ {
+			fmt.Printf("RAM[%d] = %d\n", address, m.RAM[address])
+		}
+	}
+	return nil
+}
+
+// screenImage returns the screen memory as a plain PBM image. Each row of
+// 512 pixels is 32 words, and the lowest bit of a word is its leftmost pixel.
+func screenImage(m *cpu.Machine) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "P1\n512 256\n")
+	for row := 0; row < 256; row++ {
+		for col := 0; col < 512; col++ {
+			word := uint16(m.RAM[cpu.Screen+row*32+col/16])
+			buf.WriteByte('0' + byte(word>>(col%16)&1))
+		}
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+func readFile(filename string) []byte {
+	r, err := jack.Open(filename)
+	if err != nil {
+		printErrorAndExit(err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		printErrorAndExit(err)
+	}
+	return data
+}
+
+// formatSources shows the chain of positions a ROM address came from,
+// starting with the original source.
+func formatSources(sources []jack.Position) string {
+	var parts []string
+	for i := len(sources) - 1; i >= 0; i-- {
+		parts = append(parts, sources[i].String())
+	}
+	return strings.Join(parts, " <- ")
+}
+
+// lookupMap answers questions about a program from its source maps. The
+// first map is followed through the others, so the stage maps written by
+// compile, translate and assemble -map can be used as well as the combined
+// map of build -map.
+func lookupMap(args []string) {
+	flags := flag.NewFlagSet("map", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: jack map [options] <map file> [map files it leads to...]\n")
+		flags.PrintDefaults()
+	}
+	rom := flags.Int("rom", -1, "print the source lines that ROM address, or generated line, comes from")
+	line := flags.String("line", "", "print the ROM addresses, or generated lines, that come from file:line")
+	flags.Parse(args)
+	if flags.NArg() == 0 || (*rom < 0 && *line == "") {
+		flags.Usage()
+		os.Exit(1)
+	}
+	var maps []*jack.SourceMap
+	for _, filename := range flags.Args() {
+		sourceMap, err := jack.ReadSourceMap(bytes.NewReader(readFile(filename)))
+		if err != nil {
+			printErrorAndExit(fmt.Errorf("%s: %v", filename, err))
+		}
+		maps = append(maps, sourceMap)
+	}
+	sourceMap := maps[0]
+	sourceMap.Compose(maps[1:])
+	if *rom >= 0 {
+		sources := sourceMap.Lookup(*rom)
+		if sources == nil {
+			printErrorAndExit(fmt.Sprintf("%d is not in the map", *rom))
+		}
+		fmt.Printf("%d: %s\n", *rom, formatSources(sources))
+	}
+	if *line != "" {
+		position, err := jack.ParsePosition(*line)
+		if err != nil {
+			printErrorAndExit(err)
+		}
+		// print runs of consecutive entries as one range
+		found := false
+		for i := 0; i < len(sourceMap.Entries); i++ {
+			if !hasSource(sourceMap.Entries[i], position) {
+				continue
+			}
+			j := i
+			for j+1 < len(sourceMap.Entries) && hasSource(sourceMap.Entries[j+1], position) {
+				j++
+			}
+			end := sourceMap.End(j)
+			if end < 0 {
+				fmt.Printf("%d-\n", sourceMap.Entries[i].Start)
+			} else {
+				fmt.Printf("%d-%d\n", sourceMap.Entries[i].Start, end-1)
+			}
+			found = true
+			i = j
+		}
+		if !found {
+			printErrorAndExit(fmt.Sprintf("nothing comes from %v", position))
+		}
+	}
+}
+
+func hasSource(entry jack.MapEntry, position jack.Position) bool {
+	for _, p := range entry.Sources {
+		if p == position {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"jack"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"jack/JackC/format"
+)
+
+func printErrorAndExit(err interface{}) {
+	if diagnostics, ok := err.(*jack.Diagnostics); ok {
+		err = diagnostics.Report()
+	}
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
+
+func main() {
+	var write bool
+	var showDiff bool
+	var check bool
+	flag.BoolVar(&write, "w", false, "write the result to the source file instead of standard output")
+	flag.BoolVar(&showDiff, "d", false, "show a diff of the changes instead of the result")
+	flag.BoolVar(&check, "check", false, "list the files that are not formatted and exit with status 1 if there are any")
+	diagnosticFlags := jack.AddDiagnosticFlags(flag.CommandLine)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: jackfmt [options] <jack file or directory | -> ...\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	err := diagnosticFlags.Setup()
+	if err != nil {
+		printErrorAndExit(err)
+	}
+	sources, err := jack.ResolveAll(flag.Args(), ".jack")
+	if err != nil {
+		printErrorAndExit(err)
+	}
+	// files with syntax errors are left alone, and the errors of them all
+	// are reported at the end
+	var diagnostics jack.Diagnostics
+	unformatted := 0
+	for _, src := range sources {
+		if write && src.Filename == jack.Stdio {
+			printErrorAndExit("-w cannot write to standard input")
+		}
+		source := readFile(src.Filename)
+		formatted, err := format.Source(source, src.Filename)
+		if fileDiagnostics, ok := err.(*jack.Diagnostics); ok {
+			diagnostics.Append(fileDiagnostics)
+			continue
+		} else if err != nil {
+			printErrorAndExit(err)
+		}
+		if !write && !showDiff && !check {
+			os.Stdout.Write(formatted)
+			continue
+		}
+		if bytes.Equal(source, formatted) {
+			continue
+		}
+		unformatted++
+		if check {
+			fmt.Println(src.Filename)
+		}
+		if showDiff {
+			os.Stdout.Write(diff(src.Filename, source, formatted))
+		}
+		if write {
+			err = os.WriteFile(src.Filename, formatted, 0644)
+			if err != nil {
+				printErrorAndExit(err)
+			}
+		}
+	}
+	if diagnostics.Errors() > 0 {
+		printErrorAndExit(&diagnostics)
+	}
+	if check && unformatted > 0 {
+		os.Exit(1)
+	}
+}
+
+func readFile(filename string) []byte {
+	r, err := jack.Open(filename)
+	if err != nil {
+		printErrorAndExit(err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		printErrorAndExit(err)
+	}
+	return data
+}
+
+// diff returns the changes from source to formatted as a unified diff, as
+// the diff command writes it.
+func diff(filename string, source []byte, formatted []byte) []byte {
+	dir, err := os.MkdirTemp("", "jackfmt")
+	if err != nil {
+		printErrorAndExit(err)
+	}
+	defer os.RemoveAll(dir)
+	before, after := filepath.Join(dir, "before.jack"), filepath.Join(dir, "after.jack")
+	for name, data := range map[string][]byte{before: source, after: formatted} {
+		err = os.WriteFile(name, data, 0644)
+		if err != nil {
+			printErrorAndExit(err)
+		}
+	}
+	output, err := exec.Command("diff", "-u", "--label", filename+".orig", "--label", filename, before, after).Output()
+	// diff exits with status 1 when the files differ
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+		err = nil
+	}
+	if err != nil {
+		printErrorAndExit(fmt.Errorf("running diff: %v", err))
+	}
+	return output
+}
@@ -3,6 +3,7 @@ package jack
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 )
@@ -17,18 +18,22 @@ func trimLine(line string) string {
 }
 
 func ForLinesInFile(filename string, processLine func(line string, lineno int, origLine string) error) {
-	lineno := 0
 	f, err := os.Open(filename)
 	if err != nil {
 		panic(err)
 	}
 	defer f.Close()
-	scanner := bufio.NewScanner(f)
+	ForLinesInReader(f, processLine)
+}
+
+func ForLinesInReader(r io.Reader, processLine func(line string, lineno int, origLine string) error) {
+	lineno := 0
+	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
 		lineno++
 		origLine := scanner.Text()
 		line := trimLine(origLine)
-		err = processLine(line, lineno, origLine)
+		err := processLine(line, lineno, origLine)
 		if err != nil {
 			fmt.Printf("line %d, %v\n", lineno, err)
 			os.Exit(1)
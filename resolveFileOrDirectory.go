@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
@@ -39,6 +40,8 @@ func Resolve(path string, filetype string) (basename string, filenames []string,
 				filenames = append(filenames, filepath.Join(dirpath, fname))
 			}
 		}
+		// the directory order is arbitrary, sort so output does not depend on it
+		sort.Strings(filenames)
 	} else {
 		if !strings.HasSuffix(path, filetype) {
 			err = fmt.Errorf("File %s of wrong type, must be of type %s", path, filetype)
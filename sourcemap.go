@@ -0,0 +1,184 @@
+package jack
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Position is a line of a source file. The zero Position stands for code
+// that does not come from any source line, such as bootstrap code.
+type Position struct {
+	Filename string
+	Line     int
+}
+
+func (p Position) String() string {
+	if p.Filename == "" {
+		return "-"
+	}
+	return fmt.Sprintf("%s:%d", p.Filename, p.Line)
+}
+
+// ParsePosition parses the file:line form written by Position.String.
+func ParsePosition(s string) (Position, error) {
+	if s == "-" {
+		return Position{}, nil
+	}
+	colonIdx := strings.LastIndex(s, ":")
+	if colonIdx < 0 {
+		return Position{}, fmt.Errorf("position %s is not of the form file:line", s)
+	}
+	line, err := strconv.Atoi(s[colonIdx+1:])
+	if err != nil {
+		return Position{}, fmt.Errorf("position %s is not of the form file:line", s)
+	}
+	return Position{Filename: s[0:colonIdx], Line: line}, nil
+}
+
+// MapEntry says that the lines of a generated file from Start up to the
+// Start of the next entry come from Sources[0], which in turn came from
+// Sources[1] and so on.
+type MapEntry struct {
+	Start   int
+	Sources []Position
+}
+
+// SourceMap records where the lines of a generated file, or the instructions
+// of a Hack binary, came from.
+type SourceMap struct {
+	Generated string // name of the generated file
+	Entries   []MapEntry
+}
+
+// Add records that the generated lines from start on come from source. It
+// must be called with increasing start.
+func (m *SourceMap) Add(start int, source Position) {
+	if n := len(m.Entries); n > 0 && m.Entries[n-1].Sources[0] == source {
+		return
+	}
+	m.Entries = append(m.Entries, MapEntry{Start: start, Sources: []Position{source}})
+}
+
+// Lookup returns the sources of generated line n, nil if it is not mapped.
+func (m *SourceMap) Lookup(n int) []Position {
+	i := sort.Search(len(m.Entries), func(i int) bool {
+		return m.Entries[i].Start > n
+	})
+	if i == 0 {
+		return nil
+	}
+	return m.Entries[i-1].Sources
+}
+
+// End returns the generated line after the one entry i covers, or -1 for
+// the last entry, which covers everything after its start.
+func (m *SourceMap) End(i int) int {
+	if i+1 < len(m.Entries) {
+		return m.Entries[i+1].Start
+	}
+	return -1
+}
+
+// Compose follows the sources of every entry of m through the maps of the
+// files they name, as far as there are maps, so that for example the map of
+// a Hack binary leads through the assembly and the VM code to Jack lines.
+func (m *SourceMap) Compose(maps []*SourceMap) {
+	byGenerated := make(map[string]*SourceMap)
+	for _, other := range maps {
+		byGenerated[other.Generated] = other
+	}
+	for i := range m.Entries {
+		sources := m.Entries[i].Sources
+		for {
+			last := sources[len(sources)-1]
+			next, ok := byGenerated[last.Filename]
+			if !ok || last.Filename == "" {
+				break
+			}
+			found := next.Lookup(last.Line)
+			if found == nil {
+				break
+			}
+			sources = append(sources, found...)
+		}
+		m.Entries[i].Sources = sources
+	}
+}
+
+// Write writes the map as text: a header naming the generated file, then
+// one line per entry with its start and sources separated by tabs.
+func (m *SourceMap) Write(wrt io.Writer) error {
+	bw := bufio.NewWriter(wrt)
+	fmt.Fprintf(bw, "sourcemap\t%s\n", m.Generated)
+	for _, entry := range m.Entries {
+		fmt.Fprintf(bw, "%d", entry.Start)
+		for _, p := range entry.Sources {
+			fmt.Fprintf(bw, "\t%v", p)
+		}
+		fmt.Fprintln(bw)
+	}
+	return bw.Flush()
+}
+
+// ReadSourceMap reads a map written by SourceMap.Write.
+func ReadSourceMap(r io.Reader) (*SourceMap, error) {
+	m := &SourceMap{}
+	scanner := bufio.NewScanner(r)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		fields := strings.Split(scanner.Text(), "\t")
+		if lineno == 1 {
+			if len(fields) != 2 || fields[0] != "sourcemap" {
+				return nil, fmt.Errorf("not a source map")
+			}
+			m.Generated = fields[1]
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: expected a start and a source", lineno)
+		}
+		start, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineno, err)
+		}
+		entry := MapEntry{Start: start}
+		for _, field := range fields[1:] {
+			p, err := ParsePosition(field)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", lineno, err)
+			}
+			entry.Sources = append(entry.Sources, p)
+		}
+		m.Entries = append(m.Entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if lineno == 0 {
+		return nil, fmt.Errorf("not a source map")
+	}
+	return m, nil
+}
+
+// WriteFile writes the map to the file named after the generated file.
+func (m *SourceMap) WriteFile() error {
+	wrt, err := Create(m.Generated + ".map")
+	if err != nil {
+		return err
+	}
+	defer wrt.Close()
+	return m.Write(wrt)
+}
+
+// Append adds the entries of other, whose generated lines follow offset
+// lines generated before.
+func (m *SourceMap) Append(other *SourceMap, offset int) {
+	for _, entry := range other.Entries {
+		m.Entries = append(m.Entries, MapEntry{Start: entry.Start + offset, Sources: entry.Sources})
+	}
+}